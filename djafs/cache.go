@@ -0,0 +1,143 @@
+package djafs
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache is a memory-bounded least-recently-used cache.
+//
+// Every item carries an estimated size in bytes. When adding an item pushes
+// the total over the limit, the least recently accessed items are evicted
+// until it fits again. Items larger than the whole limit are never cached,
+// and a limit of zero or less disables caching entirely. A nil cache caches
+// nothing.
+type Cache[V any] struct {
+	limit int64
+	size  int64
+	items map[string]*list.Element
+	order *list.List // Front is the most recently accessed item
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	mu        sync.Mutex
+}
+
+// CacheStats is a snapshot of a cache's counters
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Bytes     int64
+	Limit     int64
+}
+
+type cacheItem[V any] struct {
+	key   string
+	value V
+	size  int64
+}
+
+// NewCache creates a cache holding at most limit bytes
+func NewCache[V any](limit int64) *Cache[V] {
+	return &Cache[V]{
+		limit: limit,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Get returns the cached value for key and marks it as recently accessed
+func (c *Cache[V]) Get(key string) (V, bool) {
+	if c == nil {
+		var zero V
+		return zero, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.misses++
+		var zero V
+		return zero, false
+	}
+
+	c.hits++
+	item := elem.Value.(*cacheItem[V])
+	c.order.MoveToFront(elem)
+	return item.value, true
+}
+
+// Add caches value under key, replacing any previous value, and evicts the
+// least recently accessed items until the cache fits its limit again
+func (c *Cache[V]) Add(key string, value V, size int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+	if c.limit <= 0 || size > c.limit {
+		return
+	}
+
+	item := &cacheItem[V]{key: key, value: value, size: size}
+	c.items[key] = c.order.PushFront(item)
+	c.size += size
+
+	for c.size > c.limit {
+		oldest := c.order.Back()
+		c.remove(oldest.Value.(*cacheItem[V]).key)
+		c.evictions++
+	}
+}
+
+// Remove drops key from the cache
+func (c *Cache[V]) Remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+}
+
+// Limit returns the cache's size limit in bytes
+func (c *Cache[V]) Limit() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.limit
+}
+
+// Stats returns the current counters
+func (c *Cache[V]) Stats() CacheStats {
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   len(c.items),
+		Bytes:     c.size,
+		Limit:     c.limit,
+	}
+}
+
+// remove drops key without locking. The caller must hold c.mu.
+func (c *Cache[V]) remove(key string) {
+	elem, ok := c.items[key]
+	if !ok {
+		return
+	}
+	c.order.Remove(elem)
+	delete(c.items, key)
+	c.size -= elem.Value.(*cacheItem[V]).size
+}
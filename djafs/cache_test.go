@@ -0,0 +1,144 @@
+package djafs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+func TestCache_EvictsLeastRecentlyAccessed(t *testing.T) {
+	c := NewCache[string](30)
+	c.Add("a", "A", 10)
+	c.Add("b", "B", 10)
+	c.Add("c", "C", 10)
+
+	// Touch a so that b is the oldest
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("Expected a to be cached")
+	}
+	c.Add("d", "D", 10)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+
+	stats := c.Stats()
+	if stats.Evictions != 1 || stats.Entries != 3 || stats.Bytes != 30 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if stats.Hits != 4 || stats.Misses != 1 {
+		t.Errorf("Expected 4 hits and 1 miss, got %+v", stats)
+	}
+}
+
+func TestCache_SizeAccounting(t *testing.T) {
+	c := NewCache[int](100)
+
+	c.Add("big", 1, 101)
+	if _, ok := c.Get("big"); ok {
+		t.Error("Items larger than the limit should not be cached")
+	}
+
+	c.Add("x", 1, 40)
+	c.Add("x", 2, 60)
+	if v, _ := c.Get("x"); v != 2 {
+		t.Errorf("Expected replaced value 2, got %d", v)
+	}
+	if got := c.Stats().Bytes; got != 60 {
+		t.Errorf("Replacing an item should update its size, got %d bytes", got)
+	}
+
+	c.Remove("x")
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected empty cache after Remove, got %+v", stats)
+	}
+
+	var disabled *Cache[int]
+	disabled.Add("x", 1, 1)
+	if _, ok := disabled.Get("x"); ok {
+		t.Error("A nil cache should cache nothing")
+	}
+
+	zero := NewCache[int](0)
+	zero.Add("empty", 1, 0)
+	if _, ok := zero.Get("empty"); ok {
+		t.Error("A zero limit should cache nothing, not even empty items")
+	}
+}
+
+func TestFS_LookupCacheIsBounded(t *testing.T) {
+	storage := t.TempDir()
+	fsys := &FS{StoragePath: storage, Archives: NewCache[*Archive](1024)}
+
+	// Each table is ~600 bytes, so only one fits at a time
+	var manifests []string
+	for i := range 3 {
+		var entries []util.LookupEntry
+		for j := range 5 {
+			entries = append(entries, util.LookupEntry{
+				Name:   fmt.Sprintf("file-%d.json", j),
+				Target: util.HashPathFromHash(fmt.Sprintf("%064x", i*10+j)),
+			})
+		}
+		manifests = append(manifests, writeLookupTable(t, filepath.Join(storage, fmt.Sprint(i)), entries...))
+	}
+
+	for _, manifest := range manifests {
+		if _, err := fsys.loadLookupTable(manifest); err != nil {
+			t.Fatalf("loadLookupTable failed: %v", err)
+		}
+	}
+
+	stats := fsys.Stats().LookupCache
+	if stats.Bytes > stats.Limit {
+		t.Errorf("Cache holds %d bytes, over its %d byte limit", stats.Bytes, stats.Limit)
+	}
+	if stats.Evictions != 2 || stats.Entries != 1 {
+		t.Errorf("Expected 2 evictions leaving 1 table, got %+v", stats)
+	}
+	if _, ok := fsys.Archives.Get(manifests[2]); !ok {
+		t.Error("Most recently loaded table should stay cached")
+	}
+}
+
+func TestFileRead_CachesSmallContent(t *testing.T) {
+	storage := t.TempDir()
+	content := testContent(4096)
+	target := util.HashPathFromHash(fmt.Sprintf("%064x", 7))
+
+	archivePath := filepath.Join(storage, "1-00000.djfz")
+	writeTestArchive(t, archivePath, map[string][]byte{target: content}, zip.Deflate)
+
+	targets, _ := util.LoadTargetIndex(storage)
+	targets.Set(target, archivePath)
+	fsys := &FS{StoragePath: storage, Targets: targets, Content: NewCache[[]byte](1 << 20)}
+	entry := &util.LookupEntry{Name: "small.json", Target: target, FileSize: int64(len(content))}
+
+	for range 2 {
+		f := &File{fs: fsys, entry: entry}
+		req := &fuse.ReadRequest{Offset: 100, Size: 200}
+		resp := &fuse.ReadResponse{Data: make([]byte, 0, req.Size)}
+		if err := f.newHandle(fuse.OpenReadOnly).Read(context.Background(), req, resp); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if !bytes.Equal(resp.Data, content[100:300]) {
+			t.Fatal("Read returned wrong data")
+		}
+	}
+
+	stats := fsys.Stats().ContentCache
+	if stats.Hits != 1 || stats.Entries != 1 || stats.Bytes != int64(len(content)) {
+		t.Errorf("Expected second read to hit the content cache, got %+v", stats)
+	}
+}
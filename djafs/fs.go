@@ -1,16 +1,16 @@
 package djafs
 
 import (
-	"archive/zip"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -21,17 +21,21 @@ import (
 
 // FS implements the djafs FUSE filesystem
 type FS struct {
-	StoragePath string              // Path to djafs storage directory
-	Archives    map[string]*Archive // Cached archive handles
-	HotCache    *HotCache           // Write buffer
-	mu          sync.RWMutex        // Protects Archives map
+	StoragePath string            // Path to djafs storage directory
+	Archives    *Cache[*Archive]  // Cached lookup tables by manifest path
+	Content     *Cache[[]byte]    // Cached decompressed content by target
+	HotCache    *HotCache         // Write buffer
+	Index       *PathIndex        // Directory index for /live
+	Targets     *util.TargetIndex // Target to archive mapping
+	Inodes      *InodeMap         // Stable inode per mount path
+	Options     Options           // Options the filesystem was created with
+	pool        *archivePool      // Open archives shared between reads
 }
 
 // Archive represents a loaded .djfz archive with its lookup table
 type Archive struct {
 	Path        string
 	LookupTable util.LookupTable
-	LastAccess  time.Time
 	mu          sync.RWMutex
 }
 
@@ -42,40 +46,159 @@ type HotCache struct {
 	fs          *FS
 	gcTicker    *time.Ticker
 	stopGC      chan bool
+	owners      map[string]util.LookupEntry // Mode and owner of hot files by logical path
+	syncHook    func(step string) error     // Called before each durable commit step in tests
+	gcMu        sync.Mutex                  // Serializes collections
+	backlog     atomic.Int64                // Files waiting to be archived, recounted by every collection
+	backlogSize atomic.Int64                // Bytes of those files
+	wake        chan struct{}               // Asks the garbage collector for a collection
+	trigger     chan struct{}               // A packing threshold was crossed
+	highWater   atomic.Bool                 // Usage is above Options.QuotaHighWater
+	rejected    atomic.Uint64               // Writes refused for lack of quota
+	spilled     atomic.Int64                // Bytes in temporary files of open handles
+	drained     chan struct{}               // Closed when a collection finished, under drainMu
+	drainMu     sync.Mutex
 	mu          sync.RWMutex
 }
 
-// NewFS creates a new djafs filesystem instance
+// NewFS creates a new djafs filesystem instance with default options
 func NewFS(storagePath string) *FS {
+	return NewFSWithOptions(storagePath, DefaultOptions())
+}
+
+// NewFSWithOptions creates a new djafs filesystem instance
+func NewFSWithOptions(storagePath string, opts Options) *FS {
+	if !opts.At.IsZero() {
+		opts.ReadOnly = true
+	}
 	fs := &FS{
 		StoragePath: storagePath,
-		Archives:    make(map[string]*Archive),
+		Archives:    NewCache[*Archive](opts.LookupCacheBytes),
+		Content:     NewCache[[]byte](opts.ContentCacheBytes),
+		Options:     opts,
+		pool:        newArchivePool(opts.OpenArchives),
+	}
+
+	// Bring the persisted index up to date before serving any requests
+	fs.Index = LoadPathIndex(storagePath)
+	if err := fs.Index.Sync(); err != nil {
+		fmt.Printf("Error syncing path index: %v\n", err)
+	}
+	if !opts.ReadOnly {
+		if err := fs.Index.Save(); err != nil {
+			fmt.Printf("Error saving path index: %v\n", err)
+		}
+	}
+
+	// Never hand out an inode that is already stored somewhere
+	fs.Inodes = LoadInodeMap(storagePath, fs.Index)
+	util.SetInode(fs.Index.MaxInode())
+
+	targets, err := util.LoadTargetIndex(storagePath)
+	if err != nil {
+		fmt.Printf("Ignoring unreadable target index: %v\n", err)
 	}
+	fs.Targets = targets
 
-	fs.HotCache = NewHotCache(fs, storagePath)
+	if !opts.ReadOnly {
+		fs.HotCache = NewHotCache(fs, storagePath)
+	}
 	return fs
 }
 
+// Stats returns the current cache counters
+func (fs *FS) Stats() Stats {
+	return Stats{
+		LookupCache:  fs.Archives.Stats(),
+		ContentCache: fs.Content.Stats(),
+		HotCache:     fs.HotCache.Stats(),
+		OpenArchives: fs.pool.Open(),
+	}
+}
+
+// readOnly reports whether the mount refuses changes
+func (fs *FS) readOnly() bool {
+	return fs != nil && fs.Options.ReadOnly
+}
+
+// statfsNameLen is the longest file name the mount reports accepting
+const statfsNameLen = 255
+
+// Statfs reports the uncompressed size of the stored files as used space,
+// so df shows the logical usage, next to the free space and inodes of the
+// backing filesystem. Sizes come from the path index, not from a walk.
+func (fs *FS) Statfs(ctx context.Context, req *fuse.StatfsRequest, resp *fuse.StatfsResponse) error {
+	var backing syscall.Statfs_t
+	if err := syscall.Statfs(fs.StoragePath, &backing); err != nil {
+		return err
+	}
+
+	bytes, files := fs.Index.Usage()
+	bsize := uint64(backing.Bsize)
+	used := (bytes + bsize - 1) / bsize
+
+	resp.Bsize = uint32(bsize)
+	resp.Frsize = uint32(bsize)
+	resp.Blocks = used + backing.Bavail
+	resp.Bfree = backing.Bavail
+	resp.Bavail = backing.Bavail
+	resp.Files = files + backing.Ffree
+	resp.Ffree = backing.Ffree
+	resp.Namelen = statfsNameLen
+	return nil
+}
+
 // Stop gracefully shuts down the filesystem
 func (fs *FS) Stop() {
 	if fs.HotCache != nil {
 		fs.HotCache.Stop()
 	}
+	if fs.Options.ReadOnly {
+		fs.pool.Close()
+		return
+	}
+	if fs.Index != nil {
+		if err := fs.Index.Save(); err != nil {
+			fmt.Printf("Error saving path index: %v\n", err)
+		}
+	}
+	if fs.Inodes != nil {
+		if err := fs.Inodes.Save(); err != nil {
+			fmt.Printf("Error saving inode map: %v\n", err)
+		}
+	}
+	fs.pool.Close()
 }
 
 // NewHotCache creates a new hot cache instance
 func NewHotCache(fs *FS, storagePath string) *HotCache {
+	interval := fs.Options.GCInterval
+	if interval <= 0 {
+		interval = DefaultOptions().GCInterval
+	}
 	hc := &HotCache{
 		IncomingDir: filepath.Join(storagePath, "hot_cache", "incoming"),
 		StagingDir:  filepath.Join(storagePath, "hot_cache", "staging"),
 		fs:          fs,
-		gcTicker:    time.NewTicker(30 * time.Second), // GC every 30 seconds
+		gcTicker:    time.NewTicker(interval),
 		stopGC:      make(chan bool),
+		wake:        make(chan struct{}, 1),
+		trigger:     make(chan struct{}, 1),
+		drained:     make(chan struct{}),
 	}
 
 	// Create directories
 	os.MkdirAll(hc.IncomingDir, 0755)
 	os.MkdirAll(hc.StagingDir, 0755)
+	hc.clearSpill()
+
+	// Deletions journaled before the last unmount are still pending
+	hc.restorePending()
+	hc.restoreOwners()
+
+	// Files a crash left halfway through the pipeline
+	hc.recoverStaging()
+	hc.recount()
 
 	// Start background garbage collection
 	go hc.backgroundGC()
@@ -83,8 +206,18 @@ func NewHotCache(fs *FS, storagePath string) *HotCache {
 	return hc
 }
 
-// Root returns the root directory node
+// Root returns the root directory node, or the snapshot directory of a
+// snapshot mount
 func (fs *FS) Root() (fs.Node, error) {
+	if !fs.Options.At.IsZero() {
+		at := fs.Options.At
+		return &Dir{
+			fs:           fs,
+			path:         "/snapshots/@" + at.Format(time.RFC3339Nano),
+			isSnapshot:   true,
+			snapshotTime: &at,
+		}, nil
+	}
 	return &Dir{
 		fs:   fs,
 		path: "/",
@@ -101,19 +234,50 @@ type Dir struct {
 
 // Attr returns directory attributes
 func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
-	a.Inode = 1 // Root directory gets inode 1
-	a.Mode = os.ModeDir | 0o755
+	a.Inode = d.fs.Inodes.Get(d.path)
+	d.fs.fillOwner(a, d.ownerEntry(), true)
 	a.Mtime = time.Now()
 	a.Ctime = time.Now()
 	a.Atime = time.Now()
 	return nil
 }
 
+// ownerEntry returns the recorded entry of a /live directory, which may
+// hold its mode and owner
+func (d *Dir) ownerEntry() util.LookupEntry {
+	if !strings.HasPrefix(d.path, "/live/") {
+		return util.LookupEntry{}
+	}
+	if current, ok := d.fs.Index.Current(livePath(d.path)); ok && current.IsDir() {
+		return current
+	}
+	return util.LookupEntry{}
+}
+
+// Setattr changes the mode and owner of a /live directory
+func (d *Dir) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if !changesOwner(req) {
+		return d.Attr(ctx, &resp.Attr)
+	}
+	if d.fs.readOnly() {
+		return syscall.EROFS
+	}
+	if !strings.HasPrefix(d.path, "/live/") {
+		return syscall.EPERM
+	}
+
+	owner := d.fs.applyOwner(d.ownerEntry(), req, true)
+	if err := d.fs.HotCache.SetOwner(livePath(d.path), owner); err != nil {
+		return err
+	}
+	return d.Attr(ctx, &resp.Attr)
+}
+
 // Lookup resolves file/directory names to nodes
 func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 	switch d.path {
 	case "/":
-		// Root directory - only allow "live" and "snapshots"
+		// Root directory - only allow "live", "snapshots" and "versions"
 		switch name {
 		case "live":
 			return &Dir{
@@ -126,6 +290,11 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 				path:       "/snapshots",
 				isSnapshot: true,
 			}, nil
+		case "versions":
+			return &Dir{
+				fs:   d.fs,
+				path: "/versions",
+			}, nil
 		}
 		return nil, syscall.ENOENT
 
@@ -144,6 +313,9 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 		} else if strings.HasPrefix(d.path, "/snapshots/") {
 			// Within snapshot directory structure
 			return d.resolveSnapshotPath(name)
+		} else if d.path == "/versions" || strings.HasPrefix(d.path, "/versions/") {
+			// Within the version history
+			return d.resolveVersionPath(name)
 		}
 	}
 
@@ -153,23 +325,34 @@ func (d *Dir) Lookup(ctx context.Context, name string) (fs.Node, error) {
 // resolveLivePath resolves paths within the /live directory
 func (d *Dir) resolveLivePath(name string) (fs.Node, error) {
 	// Construct the full path
-	fullPath := filepath.Join(strings.TrimPrefix(d.path, "/live"), name)
-	if fullPath == "." {
-		fullPath = name
+	fullPath := path.Join(livePath(d.path), name)
+
+	// Files not archived yet are read back from the hot cache
+	if hf, ok := d.fs.HotCache.Stat(fullPath); ok && d.fs.hotWins(fullPath, hf) {
+		entry := hotEntry(fullPath, hf)
+		if owner, ok := d.fs.HotCache.Owner(fullPath); ok {
+			copyOwner(&entry, owner)
+		}
+		return &File{
+			fs:    d.fs,
+			entry: &entry,
+			inode: d.fs.Inodes.Get("/live/" + fullPath),
+			isHot: true,
+		}, nil
 	}
 
-	// Try to find the file in storage
-	entry, err := d.fs.findFileEntry(fullPath)
-	if err == nil {
+	// Try to find the file in the index
+	if entry, ok := d.fs.Index.Lookup(fullPath); ok {
 		// Found a file
 		return &File{
 			fs:    d.fs,
-			entry: entry,
+			entry: &entry,
+			inode: d.fs.Inodes.Get("/live/" + fullPath),
 		}, nil
 	}
 
-	// Check if it's a directory by looking for files with this prefix
-	if d.fs.hasFilesWithPrefix(fullPath + "/") {
+	// Check if it's a directory with live files below it
+	if d.fs.Index.IsDir(fullPath) || d.fs.HotCache.IsDir(fullPath) {
 		return &Dir{
 			fs:   d.fs,
 			path: "/live/" + fullPath,
@@ -179,127 +362,17 @@ func (d *Dir) resolveLivePath(name string) (fs.Node, error) {
 	return nil, syscall.ENOENT
 }
 
-// resolveSnapshotPath resolves paths within the /snapshots directory
-func (d *Dir) resolveSnapshotPath(name string) (fs.Node, error) {
-	if d.path == "/snapshots" {
-		// Root snapshots directory - handle year or "latest"
-		switch name {
-		case "latest":
-			return &Dir{
-				fs:           d.fs,
-				path:         "/snapshots/latest",
-				isSnapshot:   true,
-				snapshotTime: nil, // nil means latest
-			}, nil
-		default:
-			// Check if it's a valid year
-			if len(name) == 4 {
-				if _, err := strconv.Atoi(name); err == nil {
-					// Valid year
-					return &Dir{
-						fs:         d.fs,
-						path:       "/snapshots/" + name,
-						isSnapshot: true,
-					}, nil
-				}
-			}
-		}
-
-		return nil, syscall.ENOENT
-	}
-
-	// Handle hierarchical snapshot paths
-	pathParts := strings.Split(d.path, "/")
-
-	if len(pathParts) == 3 {
-		// /snapshots/YYYY -> /snapshots/YYYY/MM
-		year := pathParts[2]
-		if year == "latest" {
-			// Handle latest snapshot navigation
-			return &Dir{
-				fs:           d.fs,
-				path:         d.path + "/" + name,
-				isSnapshot:   true,
-				snapshotTime: nil,
-			}, nil
-		} else {
-			// Check if it's a valid month (01-12)
-			if len(name) == 2 {
-				if month, err := strconv.Atoi(name); err == nil && month >= 1 && month <= 12 {
-					return &Dir{
-						fs:         d.fs,
-						path:       d.path + "/" + name,
-						isSnapshot: true,
-					}, nil
-				}
-			}
-		}
-	} else if len(pathParts) == 4 {
-		// /snapshots/YYYY/MM -> /snapshots/YYYY/MM/DD
-		year := pathParts[2]
-		month := pathParts[3]
-
-		// Check if it's a valid day (01-31)
-		if len(name) == 2 {
-			if day, err := strconv.Atoi(name); err == nil && day >= 1 && day <= 31 {
-				// Validate the complete date
-				dateStr := year + "/" + month + "/" + name
-				if _, err := time.Parse("2006/01/02", dateStr); err == nil {
-					return &Dir{
-						fs:         d.fs,
-						path:       d.path + "/" + name,
-						isSnapshot: true,
-					}, nil
-				}
-			}
-		}
-	} else if len(pathParts) >= 5 {
-		// /snapshots/YYYY/MM/DD/... -> navigate within snapshot
-		year := pathParts[2]
-		month := pathParts[3]
-		day := pathParts[4]
-
-		// Parse the date and set snapshot time
-		dateStr := year + "/" + month + "/" + day
-		var snapshotTime *time.Time
-		if date, err := time.Parse("2006/01/02", dateStr); err == nil {
-			endOfDay := date.Add(23*time.Hour + 59*time.Minute + 59*time.Second)
-			snapshotTime = &endOfDay
-		}
-
-		// Build the virtual path within the snapshot
-		virtualPath := strings.Join(pathParts[5:], "/")
-		if virtualPath != "" {
-			virtualPath = "/" + virtualPath
-		}
-		virtualPath = virtualPath + "/" + name
-
-		// Try to find the file at the snapshot time
-		entry, err := d.fs.findFileEntryAtTime(virtualPath, snapshotTime)
-		if err == nil {
-			// Found a file
-			return &File{
-				fs:    d.fs,
-				entry: entry,
-			}, nil
-		}
-
-		// Check if it's a directory by looking for files with this prefix at snapshot time
-		if d.fs.hasFilesWithPrefixAtTime(virtualPath+"/", snapshotTime) {
-			return &Dir{
-				fs:           d.fs,
-				path:         d.path + "/" + name,
-				isSnapshot:   true,
-				snapshotTime: snapshotTime,
-			}, nil
-		}
-	}
-
-	return nil, syscall.ENOENT
+// livePath converts a /live directory path to the logical path used by the
+// index, e.g. "/live/a/b" becomes "a/b" and "/live" becomes "".
+func livePath(dirPath string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(dirPath, "/live"), "/")
 }
 
 // Create creates a new file
 func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fs.Node, fs.Handle, error) {
+	if d.fs.readOnly() {
+		return nil, nil, syscall.EROFS
+	}
 	// Only allow creation in /live directory
 	if !strings.HasPrefix(d.path, "/live") {
 		return nil, nil, syscall.EPERM
@@ -310,29 +383,50 @@ func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.Cr
 		fs:       d.fs,
 		path:     filepath.Join(strings.TrimPrefix(d.path, "/live"), req.Name),
 		isNew:    true,
-		data:     []byte{},
 		modified: time.Now(),
+		owner: util.LookupEntry{
+			Mode: toStMode(req.Mode, false),
+			Uid:  req.Header.Uid,
+			Gid:  req.Header.Gid,
+		},
 	}
+	file.inode = d.fs.Inodes.Get("/live" + file.path)
+
+	// The new file is stored on Flush even if nothing is written
+	handle := file.newHandle(req.Flags)
+	handle.loaded = true
+	handle.dirty = true
 
 	// Set response attributes
-	resp.Attr.Inode = util.GetNewInode()
-	resp.Attr.Mode = req.Mode
+	resp.Attr.Inode = file.inode
+	d.fs.fillOwner(&resp.Attr, file.owner, false)
 	resp.Attr.Size = 0
 	resp.Attr.Mtime = file.modified
 	resp.Attr.Ctime = file.modified
 	resp.Attr.Atime = file.modified
 
-	return file, file, nil
+	return file, handle, nil
 }
 
 // Mkdir creates a new directory
 func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error) {
+	if d.fs.readOnly() {
+		return nil, syscall.EROFS
+	}
 	// Only allow creation in /live directory
 	if !strings.HasPrefix(d.path, "/live") {
 		return nil, syscall.EPERM
 	}
 
 	newPath := filepath.Join(d.path, req.Name)
+	owner := util.LookupEntry{
+		Mode: toStMode(req.Mode, true),
+		Uid:  req.Header.Uid,
+		Gid:  req.Header.Gid,
+	}
+	if err := d.fs.HotCache.MakeDir(livePath(newPath), owner); err != nil {
+		return nil, err
+	}
 
 	return &Dir{
 		fs:   d.fs,
@@ -340,6 +434,60 @@ func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fs.Node, error
 	}, nil
 }
 
+// Remove deletes a file or an empty directory
+func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	if d.fs.readOnly() {
+		return syscall.EROFS
+	}
+	// Only allow deletion in /live directory
+	if !strings.HasPrefix(d.path, "/live") {
+		return syscall.EPERM
+	}
+
+	p := path.Join(livePath(d.path), req.Name)
+	if !req.Dir {
+		if d.fs.Index.IsDir(p) || d.fs.HotCache.IsDir(p) {
+			return syscall.EISDIR
+		}
+		if err := d.fs.HotCache.RemoveFile(p); err != nil {
+			return err
+		}
+		d.fs.Inodes.Forget("/live/" + p)
+		return nil
+	}
+
+	if len(d.fs.liveChildren(p)) > 0 {
+		return syscall.ENOTEMPTY
+	}
+	if _, ok := d.fs.Index.Lookup(p); ok {
+		return syscall.ENOTDIR
+	}
+	if err := d.fs.HotCache.RemoveDir(p); err != nil {
+		return err
+	}
+	d.fs.Inodes.Forget("/live/" + p)
+	return nil
+}
+
+// Rename moves a file or directory, possibly into another directory
+func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fs.Node) error {
+	if d.fs.readOnly() {
+		return syscall.EROFS
+	}
+	target, ok := newDir.(*Dir)
+	if !ok || !strings.HasPrefix(d.path, "/live") || !strings.HasPrefix(target.path, "/live") {
+		return syscall.EPERM
+	}
+
+	oldPath := path.Join(livePath(d.path), req.OldName)
+	newPath := path.Join(livePath(target.path), req.NewName)
+	if err := d.fs.HotCache.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	d.fs.Inodes.Move("/live/"+oldPath, "/live/"+newPath)
+	return nil
+}
+
 // ReadDirAll lists directory contents
 func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	var dirents []fuse.Dirent
@@ -348,20 +496,25 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 	case "/":
 		// Root directory
 		dirents = append(dirents, fuse.Dirent{
-			Inode: 2,
+			Inode: liveInode,
 			Name:  "live",
 			Type:  fuse.DT_Dir,
 		})
 		dirents = append(dirents, fuse.Dirent{
-			Inode: 3,
+			Inode: snapshotsInode,
 			Name:  "snapshots",
 			Type:  fuse.DT_Dir,
 		})
+		dirents = append(dirents, fuse.Dirent{
+			Inode: d.fs.Inodes.Get("/versions"),
+			Name:  "versions",
+			Type:  fuse.DT_Dir,
+		})
 
 	case "/snapshots":
 		// List available snapshot years plus "latest"
 		dirents = append(dirents, fuse.Dirent{
-			Inode: util.GetNewInode(),
+			Inode: d.fs.Inodes.Get("/snapshots/latest"),
 			Name:  "latest",
 			Type:  fuse.DT_Dir,
 		})
@@ -369,7 +522,7 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 		years := d.fs.getAvailableSnapshotYears()
 		for _, year := range years {
 			dirents = append(dirents, fuse.Dirent{
-				Inode: util.GetNewInode(),
+				Inode: d.fs.Inodes.Get("/snapshots/" + year),
 				Name:  year,
 				Type:  fuse.DT_Dir,
 			})
@@ -377,211 +530,37 @@ func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
 
 	case "/live":
 		// List all files and directories in live storage
-		entries, err := d.fs.getAllEntries()
-		if err != nil {
-			return nil, err
-		}
-
-		// Build directory structure
-		dirs := make(map[string]bool)
-		for _, entry := range entries {
-			if entry.Target == "" {
-				continue // Skip deleted files
-			}
-
-			parts := strings.Split(entry.Name, "/")
-			if len(parts) > 1 {
-				dirs[parts[0]] = true
-			} else {
-				// File in root of live directory
-				dirents = append(dirents, fuse.Dirent{
-					Inode: entry.Inode,
-					Name:  entry.Name,
-					Type:  fuse.DT_File,
-				})
-			}
-		}
-
-		// Add directories
-		for dir := range dirs {
-			dirents = append(dirents, fuse.Dirent{
-				Inode: util.GetNewInode(),
-				Name:  dir,
-				Type:  fuse.DT_Dir,
-			})
-		}
+		dirents = append(dirents, d.fs.liveDirents("")...)
 
 	default:
-		if after, ok := strings.CutPrefix(d.path, "/live/"); ok {
+		if strings.HasPrefix(d.path, "/live/") {
 			// List files in subdirectory
-			prefix := after + "/"
-			entries, err := d.fs.getEntriesWithPrefix(prefix)
-			if err != nil {
-				return nil, err
-			}
-
-			dirs := make(map[string]bool)
-			for _, entry := range entries {
-				if entry.Target == "" {
-					continue // Skip deleted files
-				}
-
-				relativePath := strings.TrimPrefix(entry.Name, prefix)
-				parts := strings.Split(relativePath, "/")
-
-				if len(parts) == 1 {
-					// File directly in this directory
-					dirents = append(dirents, fuse.Dirent{
-						Inode: entry.Inode,
-						Name:  parts[0],
-						Type:  fuse.DT_File,
-					})
-				} else {
-					// Subdirectory
-					dirs[parts[0]] = true
-				}
-			}
-
-			// Add directories
-			for dir := range dirs {
-				dirents = append(dirents, fuse.Dirent{
-					Inode: util.GetNewInode(),
-					Name:  dir,
-					Type:  fuse.DT_Dir,
-				})
-			}
+			dirents = append(dirents, d.fs.liveDirents(livePath(d.path))...)
 		} else if strings.HasPrefix(d.path, "/snapshots/") {
-			// Handle hierarchical snapshot directory structure
-			pathParts := strings.Split(d.path, "/")
-
-			if len(pathParts) == 3 {
-				// /snapshots/YYYY - list months
-				year := pathParts[2]
-				if year == "latest" {
-					// Handle latest snapshot - show all files
-					entries, err := d.fs.getEntriesWithPrefixAtTime("", nil)
-					if err != nil {
-						return nil, err
-					}
-
-					dirs := make(map[string]bool)
-					for _, entry := range entries {
-						if entry.Target == "" {
-							continue
-						}
-
-						parts := strings.Split(entry.Name, "/")
-						if len(parts) == 1 {
-							dirents = append(dirents, fuse.Dirent{
-								Inode: entry.Inode,
-								Name:  entry.Name,
-								Type:  fuse.DT_File,
-							})
-						} else {
-							dirs[parts[0]] = true
-						}
-					}
-
-					for dir := range dirs {
-						dirents = append(dirents, fuse.Dirent{
-							Inode: util.GetNewInode(),
-							Name:  dir,
-							Type:  fuse.DT_Dir,
-						})
-					}
-				} else {
-					// List months for this year
-					months := d.fs.getAvailableSnapshotMonths(year)
-					for _, month := range months {
-						dirents = append(dirents, fuse.Dirent{
-							Inode: util.GetNewInode(),
-							Name:  month,
-							Type:  fuse.DT_Dir,
-						})
-					}
-				}
-			} else if len(pathParts) == 4 {
-				// /snapshots/YYYY/MM - list days
-				year := pathParts[2]
-				month := pathParts[3]
-				days := d.fs.getAvailableSnapshotDays(year, month)
-				for _, day := range days {
-					dirents = append(dirents, fuse.Dirent{
-						Inode: util.GetNewInode(),
-						Name:  day,
-						Type:  fuse.DT_Dir,
-					})
-				}
-			} else if len(pathParts) >= 5 {
-				// /snapshots/YYYY/MM/DD/... - show files at that date
-				year := pathParts[2]
-				month := pathParts[3]
-				day := pathParts[4]
-
-				// Parse the date and set snapshot time
-				dateStr := year + "/" + month + "/" + day
-				var snapshotTime *time.Time
-				if date, err := time.Parse("2006/01/02", dateStr); err == nil {
-					endOfDay := date.Add(23*time.Hour + 59*time.Minute + 59*time.Second)
-					snapshotTime = &endOfDay
-				}
-
-				// Build the virtual path within the snapshot
-				virtualPath := ""
-				if len(pathParts) > 5 {
-					virtualPath = strings.Join(pathParts[5:], "/") + "/"
-				}
-
-				entries, err := d.fs.getEntriesWithPrefixAtTime(virtualPath, snapshotTime)
-				if err != nil {
-					return nil, err
-				}
-
-				dirs := make(map[string]bool)
-				for _, entry := range entries {
-					if entry.Target == "" {
-						continue // Skip deleted files
-					}
-
-					relativePath := strings.TrimPrefix(entry.Name, virtualPath)
-					parts := strings.Split(relativePath, "/")
-
-					if len(parts) == 1 {
-						// File directly in this directory
-						dirents = append(dirents, fuse.Dirent{
-							Inode: entry.Inode,
-							Name:  parts[0],
-							Type:  fuse.DT_File,
-						})
-					} else {
-						// Subdirectory
-						dirs[parts[0]] = true
-					}
-				}
-
-				// Add directories
-				for dir := range dirs {
-					dirents = append(dirents, fuse.Dirent{
-						Inode: util.GetNewInode(),
-						Name:  dir,
-						Type:  fuse.DT_Dir,
-					})
-				}
-			}
+			// List the time levels or files of a snapshot
+			return d.snapshotDirents()
+		} else if d.path == "/versions" || strings.HasPrefix(d.path, "/versions/") {
+			// List the revisions and children of a path
+			return d.versionDirents()
 		}
 	}
 
 	return dirents, nil
 }
 
-// File implements both Node and Handle for files
+// File implements Node for files. Every Open returns its own FileHandle.
 type File struct {
 	fs       *FS
 	entry    *util.LookupEntry
-	path     string    // Path for new files
-	data     []byte    // Cached file content
-	isNew    bool      // True for newly created files
-	modified time.Time // Modification time for new files
+	path     string               // Path for new files
+	isHot    bool                 // True for files read back from the hot cache
+	inode    uint64               // Inode of the mount path, entry.Inode if unset
+	isNew    bool                 // True while size and modified are newer than entry
+	snapshot bool                 // True for revisions under /snapshots and /versions
+	size     int64                // Size of new or written files
+	modified time.Time            // Modification time of new or written files
+	owner    util.LookupEntry     // Mode and owner of new or changed files
+	handles  map[*FileHandle]bool // Open handles
 	mu       sync.RWMutex
 }
 
@@ -595,15 +574,21 @@ func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
 // fillAttr fills the fuse.Attr struct without acquiring locks (helper for Attr and Setattr)
 func (f *File) fillAttr(a *fuse.Attr) error {
 	if f.isNew {
-		a.Inode = util.GetNewInode()
-		a.Mode = 0o644
-		a.Size = uint64(len(f.data))
+		a.Inode = f.inode
+		if a.Inode == 0 && f.entry != nil {
+			a.Inode = f.entry.Inode
+		}
+		f.fs.fillOwner(a, f.ownerEntry(), false)
+		a.Size = uint64(f.size)
 		a.Mtime = f.modified
 		a.Ctime = f.modified
 		a.Atime = time.Now()
 	} else {
 		a.Inode = f.entry.Inode
-		a.Mode = 0o644
+		if f.inode != 0 {
+			a.Inode = f.inode
+		}
+		f.fs.fillOwner(a, f.ownerEntry(), false)
 		a.Size = uint64(f.entry.FileSize)
 		a.Mtime = f.entry.Modified
 		a.Ctime = f.entry.Modified
@@ -612,86 +597,92 @@ func (f *File) fillAttr(a *fuse.Attr) error {
 	return nil
 }
 
-// ReadAll reads the entire file content
-func (f *File) ReadAll(ctx context.Context) ([]byte, error) {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-
-	if f.isNew {
-		return f.data, nil
-	}
-
-	if f.data != nil {
-		return f.data, nil
+// ownerEntry returns the entry holding the file's mode and owner
+func (f *File) ownerEntry() util.LookupEntry {
+	if f.owner.HasOwner() || f.entry == nil {
+		return f.owner
 	}
+	return *f.entry
+}
 
-	// Load file content from archive
-	data, err := f.fs.loadFileContent(f.entry)
-	if err != nil {
-		return nil, err
+// logicalPath returns the path of a /live file as the index knows it
+func (f *File) logicalPath() string {
+	if f.entry != nil {
+		return f.entry.Name
 	}
-
-	f.data = data
-	return data, nil
+	return strings.TrimPrefix(f.path, "/")
 }
 
-// Write writes data to the file
-func (f *File) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
-	f.mu.Lock()
-	defer f.mu.Unlock()
-
-	// Extend data slice if necessary
-	newLen := int(req.Offset) + len(req.Data)
-	if newLen > len(f.data) {
-		newData := make([]byte, newLen)
-		copy(newData, f.data)
-		f.data = newData
+// Open returns a new handle on the file. Writable handles of revisions
+// under /snapshots and /versions, and of read-only mounts, are refused.
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fs.Handle, error) {
+	writable := !req.Flags.IsReadOnly()
+	if writable && (f.snapshot || f.fs.readOnly()) {
+		return nil, syscall.EROFS
 	}
-
-	// Write the data
-	copy(f.data[req.Offset:], req.Data)
-	resp.Size = len(req.Data)
-
-	f.modified = time.Now()
-	f.isNew = true // Mark as modified
-
-	return nil
+	h := f.newHandle(req.Flags)
+	if writable && req.Flags&fuse.OpenTruncate != 0 {
+		h.resize(0)
+	}
+	return h, nil
 }
 
-// Flush ensures data is written to storage
-func (f *File) Flush(ctx context.Context, req *fuse.FlushRequest) error {
-	f.mu.RLock()
-	defer f.mu.RUnlock()
-
-	if !f.isNew || len(f.data) == 0 {
+// Fsync writes the changes of every open handle to stable storage. Without
+// any, the hot cache copy left by an earlier close is synced instead.
+func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	if f.fs.readOnly() || f.snapshot {
+		return nil
+	}
+	synced := false
+	for _, h := range f.openHandles() {
+		flushed, err := h.flush(true)
+		if err != nil {
+			return err
+		}
+		synced = synced || flushed
+	}
+	if synced {
 		return nil
 	}
 
-	// Write to hot cache
-	return f.fs.HotCache.WriteFile(f.path, f.data)
-}
-
-// Fsync forces synchronization
-func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
-	return f.Flush(ctx, &fuse.FlushRequest{})
+	f.mu.RLock()
+	p := f.logicalPath()
+	f.mu.RUnlock()
+	return f.fs.HotCache.Sync(p)
 }
 
 // Setattr sets file attributes
 func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	if f.fs.readOnly() && (req.Valid.Size() || req.Valid.Mtime() || changesOwner(req)) {
+		return syscall.EROFS
+	}
+
+	// Truncation goes through the handles, which take the file lock
+	if req.Valid.Size() {
+		if f.snapshot {
+			return syscall.EROFS
+		}
+		if err := f.truncate(ctx, req.Size); err != nil {
+			return err
+		}
+	}
+
 	f.mu.Lock()
 	defer f.mu.Unlock()
 
-	if req.Valid.Size() {
-		// Truncate or extend file
-		if req.Size < uint64(len(f.data)) {
-			f.data = f.data[:req.Size]
-		} else if req.Size > uint64(len(f.data)) {
-			newData := make([]byte, req.Size)
-			copy(newData, f.data)
-			f.data = newData
+	if changesOwner(req) {
+		if f.snapshot {
+			return syscall.EPERM
+		}
+		owner := f.fs.applyOwner(f.ownerEntry(), req, false)
+		// New files not flushed yet record it on Flush
+		if err := f.fs.HotCache.SetOwner(f.logicalPath(), owner); err != nil && !(f.isNew && err == syscall.ENOENT) {
+			return err
+		}
+		f.owner = owner
+		if f.entry != nil {
+			copyOwner(f.entry, owner)
 		}
-		f.modified = time.Now()
-		f.isNew = true
 	}
 
 	if req.Valid.Mtime() {
@@ -702,6 +693,38 @@ func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse
 	return f.fillAttr(&resp.Attr)
 }
 
+// truncate resizes the file. Open writable handles are resized, so a
+// following write through them keeps the new size; without one the
+// truncated content is written to the hot cache right away.
+func (f *File) truncate(ctx context.Context, size uint64) error {
+	handles := f.openHandles()
+	writable := false
+	for _, h := range handles {
+		if h.writable {
+			writable = true
+			h.mu.Lock()
+			err := h.resize(size)
+			h.mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if writable {
+		return nil
+	}
+
+	h := f.newHandle(fuse.OpenWriteOnly)
+	defer h.Release(ctx, &fuse.ReleaseRequest{})
+	h.mu.Lock()
+	err := h.resize(size)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return h.Flush(ctx, &fuse.FlushRequest{})
+}
+
 // Hot Cache Methods
 
 // Stop stops the hot cache garbage collection
@@ -712,24 +735,27 @@ func (hc *HotCache) Stop() {
 
 // WriteFile writes a file to the hot cache
 func (hc *HotCache) WriteFile(path string, data []byte) error {
-	hc.mu.Lock()
-	defer hc.mu.Unlock()
-
-	// Create directory structure in hot cache
-	fullPath := filepath.Join(hc.IncomingDir, path)
-	dir := filepath.Dir(fullPath)
+	return hc.writeFile(path, data, false)
+}
 
-	err := os.MkdirAll(dir, 0755)
+// writeFile writes a file to the hot cache, on stable storage when durable
+// before it returns
+func (hc *HotCache) writeFile(path string, data []byte, durable bool) error {
+	tmp, err := hc.CreateTemp()
 	if err != nil {
-		return fmt.Errorf("failed to create directory structure: %w", err)
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = hc.Commit(path, tmp, durable)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
 	}
-
-	// Write file to hot cache
-	err = os.WriteFile(fullPath, data, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to write file to hot cache: %w", err)
+		os.Remove(tmp.Name())
+		return errnoOf(err)
 	}
-
 	return nil
 }
 
@@ -738,131 +764,223 @@ func (hc *HotCache) backgroundGC() {
 	for {
 		select {
 		case <-hc.gcTicker.C:
+			hc.collect()
+		case <-hc.trigger:
+			hc.collect()
+		case <-hc.wake:
+			// Writers are waiting for room
 			hc.processFiles()
 		case <-hc.stopGC:
 			return
 		}
+		if err := hc.fs.Index.Save(); err != nil {
+			fmt.Printf("Error saving path index: %v\n", err)
+		}
+		if err := hc.fs.Inodes.Save(); err != nil {
+			fmt.Printf("Error saving inode map: %v\n", err)
+		}
 	}
 }
 
-// processFiles processes files from incoming to staging to final storage
+// processFiles archives everything in incoming: each file moves to staging,
+// its content is packed into an archive and its entry appended to the
+// lookup table of its boundary. Files that fail go back to incoming for the
+// next collection.
 func (hc *HotCache) processFiles() {
-	hc.mu.Lock()
-	defer hc.mu.Unlock()
+	hc.gcMu.Lock()
+	defer hc.gcMu.Unlock()
+
+	staged := hc.stageFiles()
+	hc.archive(staged)
+	for _, f := range staged {
+		if f.err == nil {
+			continue
+		}
+		fmt.Printf("Error processing %s: %v\n", f.stagingPath, f.err)
+		hc.mu.Lock()
+		hc.rollBack(f.stagingPath, f.relPath, f.err)
+		hc.mu.Unlock()
+	}
 
-	// Move files from incoming to staging
+	if err := hc.applyPending(); err != nil {
+		fmt.Printf("Error applying hot cache journal: %v\n", err)
+	}
+
+	hc.recount()
+}
+
+// stageFiles moves every file in incoming at least Options.MinPackAge old
+// to staging. hc.mu is only held for each move, so writers aren't blocked
+// by the walk.
+func (hc *HotCache) stageFiles() []*stagedFile {
+	settled := time.Now().Add(-hc.fs.Options.MinPackAge)
+	var found []string
 	err := filepath.Walk(hc.IncomingDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Continue on errors
 		}
 
 		if info.IsDir() {
+			// Files still being written are moved in by Commit
+			if path == hc.spillPath() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
+		if info.ModTime().After(settled) {
+			return nil // Left for a later collection
+		}
 
 		// Calculate relative path
 		relPath, err := filepath.Rel(hc.IncomingDir, path)
 		if err != nil {
 			return nil
 		}
+		found = append(found, relPath)
+		return nil
+	})
+	if err != nil {
+		// Log error but continue
+		fmt.Printf("Error during GC walk: %v\n", err)
+	}
 
-		// Move to staging
+	var staged []*stagedFile
+	emptied := make(map[string]bool)
+	for _, relPath := range found {
+		path := filepath.Join(hc.IncomingDir, relPath)
 		stagingPath := filepath.Join(hc.StagingDir, relPath)
-		stagingDir := filepath.Dir(stagingPath)
 
-		os.MkdirAll(stagingDir, 0755)
-		err = os.Rename(path, stagingPath)
+		// Move to staging, unless it was moved or removed since the walk
+		hc.mu.Lock()
+		os.MkdirAll(filepath.Dir(stagingPath), 0755)
+		err := os.Rename(path, stagingPath)
+		hc.mu.Unlock()
 		if err != nil {
-			return nil // Continue on errors
+			continue // Continue on errors
 		}
+		emptied[filepath.Dir(path)] = true
+		staged = append(staged, &stagedFile{stagingPath: stagingPath, relPath: filepath.ToSlash(relPath)})
+	}
 
-		// Process the file
-		go hc.processFile(stagingPath, relPath)
+	// Directories left behind would linger in /live listings
+	hc.mu.Lock()
+	for dir := range emptied {
+		hc.cleanupEmptyDirs(dir)
+	}
+	hc.mu.Unlock()
+	return staged
+}
 
-		return nil
-	})
-	if err != nil {
-		// Log error but continue
-		fmt.Printf("Error during GC walk: %v\n", err)
+// finishFile removes a staged file whose content is archived and whose entry
+// is in a lookup table, both on stable storage
+func (hc *HotCache) finishFile(stagingPath, relPath string, owner util.LookupEntry, hasOwner bool) {
+	// Remove from staging
+	if info, err := os.Stat(stagingPath); err == nil && os.Remove(stagingPath) == nil {
+		hc.backlog.Add(-1)
+		hc.backlogSize.Add(-info.Size())
+	}
+
+	// The lookup entry holds the owner now, unless a newer copy is waiting
+	if hasOwner {
+		hc.mu.Lock()
+		if _, hot := hc.stat(relPath); !hot && hc.owners[relPath] == owner {
+			if err := hc.forgetOwner(relPath); err != nil {
+				fmt.Printf("Error updating hot cache owners: %v\n", err)
+			}
+		}
+		hc.mu.Unlock()
 	}
+
+	// Clean up empty directories
+	hc.cleanupEmptyDirs(filepath.Dir(stagingPath))
 }
 
-// processFile processes a single file through the pipeline
-func (hc *HotCache) processFile(stagingPath, relPath string) {
+// ingest copies a staged file into the work directory and returns the
+// lookup entry for it under relPath
+func (hc *HotCache) ingest(stagingPath, relPath string) (util.LookupEntry, error) {
 	// Calculate hash
 	hash, err := util.GetFileHash(stagingPath)
 	if err != nil {
-		fmt.Printf("Error hashing file %s: %v\n", stagingPath, err)
-		return
+		return util.LookupEntry{}, fmt.Errorf("failed to hash file: %w", err)
 	}
 
 	// Copy to work directory
 	workDir := filepath.Join(hc.fs.StoragePath, util.WorkDir)
 	_, err = util.CopyToWorkDir(stagingPath, workDir, hash)
 	if err != nil {
-		fmt.Printf("Error copying file to work dir: %v\n", err)
-		return
+		return util.LookupEntry{}, fmt.Errorf("failed to copy file to work dir: %w", err)
 	}
 
 	// Create lookup entry
 	info, err := os.Stat(stagingPath)
 	if err != nil {
-		fmt.Printf("Error getting file info: %v\n", err)
-		return
+		return util.LookupEntry{}, fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	// Generate the target name for archive lookup
 	targetName := util.HashPathFromHash(hash)
 
-	entry := util.LookupEntry{
+	return util.LookupEntry{
 		FileSize: info.Size(),
 		Inode:    util.GetNewInode(),
 		Modified: info.ModTime(),
 		Name:     relPath,
 		Target:   targetName,
-	}
-
-	// Update lookup table (simplified - would need proper boundary detection)
-	err = hc.updateLookupTable(entry)
-	if err != nil {
-		fmt.Printf("Error updating lookup table: %v\n", err)
-		return
-	}
-
-	// Remove from staging
-	os.Remove(stagingPath)
-
-	// Clean up empty directories
-	hc.cleanupEmptyDirs(filepath.Dir(stagingPath))
+	}, nil
 }
 
-// updateLookupTable updates the appropriate lookup table with a new entry
-func (hc *HotCache) updateLookupTable(entry util.LookupEntry) error {
-	// For simplicity, create a lookup table in the root of storage
-	// In a real implementation, this would use proper boundary detection
-	lookupPath := filepath.Join(hc.fs.StoragePath, "lookups.djfl")
-
-	var lookupTable util.LookupTable
+// updateLookupTable adds entries, named by their logical paths, to the
+// lookup tables of their boundaries
+func (hc *HotCache) updateLookupTable(entries ...util.LookupEntry) error {
+	byManifest := make(map[string][]util.LookupEntry)
+	for _, entry := range entries {
+		manifestPath := hc.manifestFor(entry.Name)
+		byManifest[manifestPath] = append(byManifest[manifestPath], entry)
+	}
 
-	// Load existing lookup table if it exists
-	if _, err := os.Stat(lookupPath); err == nil {
-		file, err := os.Open(lookupPath)
+	for manifestPath, entries := range byManifest {
+		key, err := filepath.Rel(hc.fs.StoragePath, manifestPath)
 		if err != nil {
 			return err
 		}
-		defer file.Close()
-
-		err = json.NewDecoder(file).Decode(&lookupTable)
-		if err != nil {
+		for i := range entries {
+			entries[i].Name = relativeName(key, entries[i].Name)
+		}
+		if err := hc.appendEntries(manifestPath, entries...); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	// Add new entry
-	lookupTable.Add(entry)
+// manifestFor returns the lookup table a change to logical path p belongs
+// in. Changes to existing paths go to the table holding them, new paths to
+// the table the "dead end" algorithm finds for them, so snapshots
+// resolve them. A top-level directory no table knows yet gets a boundary
+// of its own instead of growing the root table.
+func (hc *HotCache) manifestFor(p string) string {
+	if manifestPath, ok := hc.fs.Index.Manifest(p); ok {
+		return manifestPath
+	}
 
-	// Save updated lookup table
-	return util.WriteJSONFile(lookupPath, lookupTable)
+	storagePath := filepath.Clean(hc.fs.StoragePath)
+	rootPath := filepath.Join(storagePath, "lookups.djfl")
+	manifestPath, err := util.ManifestLocationForPath(filepath.Join(storagePath, filepath.FromSlash(p)))
+	if err == nil && manifestPath != rootPath && strings.HasPrefix(manifestPath, storagePath+string(filepath.Separator)) {
+		return manifestPath
+	}
+
+	// Top-level files, and directories the root table already holds, stay
+	// in the root table
+	top, _, nested := strings.Cut(p, "/")
+	switch {
+	case !nested, hc.fs.Index.IsDir(top):
+		return rootPath
+	case top == "hot_cache", top == util.WorkDir:
+		// The index skips these directories
+		return rootPath
+	}
+	return filepath.Join(storagePath, top, "lookups.djfl")
 }
 
 // cleanupEmptyDirs removes empty directories
@@ -882,85 +1000,11 @@ func (hc *HotCache) cleanupEmptyDirs(dir string) {
 
 // FS Helper Methods
 
-// findFileEntry finds a file entry by path using the "dead end" detection algorithm
-func (fs *FS) findFileEntry(path string) (*util.LookupEntry, error) {
-	// Use the "dead end" detection algorithm from the README
-	storagePath := filepath.Join(fs.StoragePath, path)
-
-	// Walk down the storage path until we hit a "dead end"
-	currentPath := storagePath
-	for {
-		if _, err := os.Stat(currentPath); os.IsNotExist(err) {
-			// Hit a "dead end" - back up one level
-			parentPath := filepath.Dir(currentPath)
-			if parentPath == currentPath {
-				// Reached root without finding manifest
-				return nil, fmt.Errorf("no manifest found for path %s", path)
-			}
-
-			// Look for lookup table in parent directory
-			manifestPath := filepath.Join(parentPath, "lookups.djfl")
-			if _, err := os.Stat(manifestPath); err == nil {
-				// Found lookup table, search for our file
-				relativePath := strings.TrimPrefix(path, strings.TrimPrefix(parentPath, fs.StoragePath))
-				relativePath = strings.TrimPrefix(relativePath, "/")
-
-				return fs.searchLookupTable(manifestPath, relativePath)
-			}
-
-			currentPath = parentPath
-			continue
-		}
-
-		// Directory exists, check for lookup table here
-		manifestPath := filepath.Join(currentPath, "lookups.djfl")
-		if _, err := os.Stat(manifestPath); err == nil {
-			// Found lookup table, search for our file
-			relativePath := strings.TrimPrefix(path, strings.TrimPrefix(currentPath, fs.StoragePath))
-			relativePath = strings.TrimPrefix(relativePath, "/")
-
-			return fs.searchLookupTable(manifestPath, relativePath)
-		}
-
-		// Move up one level
-		parentPath := filepath.Dir(currentPath)
-		if parentPath == currentPath {
-			// Reached root
-			break
-		}
-		currentPath = parentPath
-	}
-
-	return nil, fmt.Errorf("file not found: %s", path)
-}
-
-// searchLookupTable searches a lookup table for a specific file
-func (fs *FS) searchLookupTable(manifestPath, relativePath string) (*util.LookupEntry, error) {
-	// Load lookup table
-	lookupTable, err := fs.loadLookupTable(manifestPath)
-	if err != nil {
-		return nil, err
-	}
-
-	// Search for the file
-	for entry := range lookupTable.Iterate {
-		if entry.Name == relativePath && entry.Target != "" {
-			return &entry, nil
-		}
-	}
-
-	return nil, fmt.Errorf("file not found in lookup table: %s", relativePath)
-}
-
 // loadLookupTable loads and caches a lookup table
 func (fs *FS) loadLookupTable(manifestPath string) (*util.LookupTable, error) {
-	fs.mu.RLock()
-	if archive, exists := fs.Archives[manifestPath]; exists {
-		archive.LastAccess = time.Now()
-		fs.mu.RUnlock()
+	if archive, exists := fs.Archives.Get(manifestPath); exists {
 		return &archive.LookupTable, nil
 	}
-	fs.mu.RUnlock()
 
 	// Load lookup table from file
 	file, err := os.Open(manifestPath)
@@ -976,143 +1020,164 @@ func (fs *FS) loadLookupTable(manifestPath string) (*util.LookupTable, error) {
 	}
 
 	// Cache the lookup table
-	fs.mu.Lock()
-	fs.Archives[manifestPath] = &Archive{
+	fs.Archives.Add(manifestPath, &Archive{
 		Path:        manifestPath,
 		LookupTable: lookupTable,
-		LastAccess:  time.Now(),
-	}
-	fs.mu.Unlock()
+	}, lookupTableSize(lookupTable))
 
 	return &lookupTable, nil
 }
 
-// hasFilesWithPrefix checks if any files exist with the given prefix
-func (fs *FS) hasFilesWithPrefix(prefix string) bool {
-	// Walk through all lookup tables to find files with this prefix
-	err := filepath.Walk(fs.StoragePath, func(path string, info os.FileInfo, err error) error {
+// recordedEntries returns every entry recorded for logical path p, its name
+// being the logical path. Only the lookup tables the path index knows to
+// hold p are read.
+func (fs *FS) recordedEntries(p string) ([]util.LookupEntry, error) {
+	var entries []util.LookupEntry
+	for _, manifestPath := range fs.Index.Owners(p) {
+		lookupTable, err := fs.loadLookupTable(manifestPath)
 		if err != nil {
-			return nil // Continue walking on errors
-		}
-
-		if !strings.HasSuffix(path, "lookups.djfl") {
-			return nil
+			return nil, err
 		}
-
-		lookupTable, err := fs.loadLookupTable(path)
+		key, err := filepath.Rel(fs.StoragePath, manifestPath)
 		if err != nil {
-			return nil // Continue on errors
+			return nil, err
 		}
-
 		for entry := range lookupTable.Iterate {
-			if entry.Target != "" && strings.HasPrefix(entry.Name, prefix) {
-				return fmt.Errorf("found") // Use error to break out of walk
+			if logicalPath(key, entry.Name) == p {
+				entry.Name = p
+				entries = append(entries, entry)
 			}
 		}
-
-		return nil
-	})
-
-	return err != nil && err.Error() == "found"
+	}
+	return entries, nil
 }
 
-// getAllEntries returns all file entries from all lookup tables
-func (fs *FS) getAllEntries() ([]util.LookupEntry, error) {
-	var allEntries []util.LookupEntry
+// invalidateLookupTable drops a cached lookup table after it was rewritten
+func (fs *FS) invalidateLookupTable(manifestPath string) {
+	fs.Archives.Remove(manifestPath)
+}
 
-	err := filepath.Walk(fs.StoragePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Continue walking on errors
-		}
+// lookupEntryOverhead approximates the fixed size of a decoded LookupEntry
+// beyond its strings
+const lookupEntryOverhead = 96
 
-		if !strings.HasSuffix(path, "lookups.djfl") {
-			return nil
-		}
-
-		lookupTable, err := fs.loadLookupTable(path)
-		if err != nil {
-			return nil // Continue on errors
-		}
+// lookupTableSize estimates the memory held by a decoded lookup table
+func lookupTableSize(lt util.LookupTable) int64 {
+	var size int64
+	for entry := range lt.Iterate {
+		size += int64(len(entry.Name)+len(entry.Target)) + lookupEntryOverhead
+	}
+	return size
+}
 
-		for entry := range lookupTable.Iterate {
-			allEntries = append(allEntries, entry)
+// liveDirents lists a /live directory from the path index and the hot cache
+func (fs *FS) liveDirents(dir string) []fuse.Dirent {
+	var dirents []fuse.Dirent
+	for _, child := range fs.liveChildren(dir) {
+		inode := fs.Inodes.Get("/live/" + path.Join(dir, child.Name))
+		if child.IsDir {
+			dirents = append(dirents, fuse.Dirent{
+				Inode: inode,
+				Name:  child.Name,
+				Type:  fuse.DT_Dir,
+			})
+			continue
 		}
-
-		return nil
-	})
-
-	return allEntries, err
+		dirents = append(dirents, fuse.Dirent{
+			Inode: inode,
+			Name:  child.Name,
+			Type:  fuse.DT_File,
+		})
+	}
+	return dirents
 }
 
-// getEntriesWithPrefix returns entries that start with the given prefix
-func (fs *FS) getEntriesWithPrefix(prefix string) ([]util.LookupEntry, error) {
-	var matchingEntries []util.LookupEntry
+// cacheable reports whether content of size bytes goes through the content
+// cache. Larger files are streamed so they can't flush the cache.
+func (fs *FS) cacheable(size int64) bool {
+	return fs.Content != nil && size <= fs.Content.Limit()/8
+}
 
-	err := filepath.Walk(fs.StoragePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Continue walking on errors
-		}
+// loadFileContent loads file content from the appropriate archive
+func (fs *FS) loadFileContent(entry *util.LookupEntry) ([]byte, error) {
+	// Find the archive containing this file
+	archivePath, err := fs.findArchiveForTarget(entry.Target)
+	if err != nil {
+		return nil, err
+	}
 
-		if !strings.HasSuffix(path, "lookups.djfl") {
-			return nil
+	content, err := fs.readArchiveMember(archivePath, entry.Target)
+	if err != nil {
+		// The index may point at a stale archive, drop it and scan
+		fs.Targets.Forget(entry.Target)
+		if scanned, scanErr := fs.scanArchivesForTarget(entry.Target); scanErr == nil && scanned != archivePath {
+			return fs.readArchiveMember(scanned, entry.Target)
 		}
+	}
+	return content, err
+}
 
-		lookupTable, err := fs.loadLookupTable(path)
-		if err != nil {
-			return nil // Continue on errors
-		}
+// openMember opens the archive member holding an entry's content for ranged reads
+func (fs *FS) openMember(entry *util.LookupEntry) (*memberReader, error) {
+	archivePath, err := fs.findArchiveForTarget(entry.Target)
+	if err != nil {
+		return nil, err
+	}
 
-		for entry := range lookupTable.Iterate {
-			if entry.Target != "" && strings.HasPrefix(entry.Name, prefix) {
-				matchingEntries = append(matchingEntries, entry)
-			}
+	reader, err := openMember(fs.pool, archivePath, entry.Target)
+	if err != nil {
+		// The index may point at a stale archive, drop it and scan
+		fs.Targets.Forget(entry.Target)
+		if scanned, scanErr := fs.scanArchivesForTarget(entry.Target); scanErr == nil && scanned != archivePath {
+			return openMember(fs.pool, scanned, entry.Target)
 		}
-
-		return nil
-	})
-
-	return matchingEntries, err
+	}
+	return reader, err
 }
 
-// loadFileContent loads file content from the appropriate archive
-func (fs *FS) loadFileContent(entry *util.LookupEntry) ([]byte, error) {
-	// Find the archive containing this file
-	archivePath, err := fs.findArchiveForTarget(entry.Target)
+// readArchiveMember reads a single member of a .djfz archive
+func (fs *FS) readArchiveMember(archivePath, target string) ([]byte, error) {
+	archive, err := fs.pool.Acquire(archivePath)
 	if err != nil {
 		return nil, err
 	}
+	defer fs.pool.Release(archive)
 
-	// Open the archive
-	r, err := zip.OpenReader(archivePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	f, ok := archive.File(target)
+	if !ok {
+		return nil, fmt.Errorf("file %s not found in archive %s", target, archivePath)
 	}
-	defer r.Close()
 
-	// Find the target file in the archive
-	for _, f := range r.File {
-		if f.Name == entry.Target {
-			rc, err := f.Open()
-			if err != nil {
-				return nil, fmt.Errorf("failed to open file %s in archive: %w", entry.Target, err)
-			}
-			defer rc.Close()
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s in archive: %w", target, err)
+	}
+	defer rc.Close()
 
-			// Read the entire file content
-			content, err := io.ReadAll(rc)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read file content: %w", err)
-			}
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file content: %w", err)
+	}
+	return content, nil
+}
 
-			return content, nil
+// findArchiveForTarget finds the .djfz archive containing a specific target file.
+// The target index answers directly; archives are only scanned on a miss.
+func (fs *FS) findArchiveForTarget(target string) (string, error) {
+	if archivePath, ok := fs.Targets.Lookup(target); ok {
+		if _, err := os.Stat(archivePath); err == nil {
+			return archivePath, nil
 		}
+		fs.Targets.Forget(target)
 	}
 
-	return nil, fmt.Errorf("file %s not found in archive %s", entry.Target, archivePath)
+	return fs.scanArchivesForTarget(target)
 }
 
-// findArchiveForTarget finds the .djfz archive containing a specific target file
-func (fs *FS) findArchiveForTarget(target string) (string, error) {
+// scanArchivesForTarget opens every archive until it finds target and
+// records the result in the target index, on disk unless the mount is
+// read-only
+func (fs *FS) scanArchivesForTarget(target string) (string, error) {
 	var foundArchive string
 
 	err := filepath.Walk(fs.StoragePath, func(path string, info os.FileInfo, err error) error {
@@ -1125,23 +1190,23 @@ func (fs *FS) findArchiveForTarget(target string) (string, error) {
 		}
 
 		// Check if this archive contains our target file
-		r, err := zip.OpenReader(path)
-		if err != nil {
+		found, err := util.CheckFileInDJFZ(path, target)
+		if err != nil || !found {
 			return nil // Continue on errors
 		}
-		defer r.Close()
-
-		for _, f := range r.File {
-			if f.Name == target {
-				foundArchive = path
-				return fmt.Errorf("found") // Use error to break out of walk
-			}
-		}
 
-		return nil
+		foundArchive = path
+		return filepath.SkipAll
 	})
 
-	if err != nil && err.Error() == "found" {
+	if err == nil && foundArchive != "" {
+		fs.Targets.Set(target, foundArchive)
+		if fs.readOnly() {
+			return foundArchive, nil // Storage is never written
+		}
+		if err := util.RecordTargets(fs.StoragePath, foundArchive, target); err != nil {
+			fmt.Printf("Error updating target index: %v\n", err)
+		}
 		return foundArchive, nil
 	}
 
@@ -1173,7 +1238,7 @@ func (fs *FS) getAvailableSnapshots() []string {
 
 		for entry := range lookupTable.Iterate {
 			// Add date-based snapshots in yyyy/mm/dd format
-			dateStr := entry.Modified.Format("2006/01/02")
+			dateStr := entry.Modified.UTC().Format("2006/01/02")
 			timestampSet[dateStr] = true
 		}
 
@@ -1209,7 +1274,7 @@ func (fs *FS) getAvailableSnapshotYears() []string {
 		}
 
 		for entry := range lookupTable.Iterate {
-			year := entry.Modified.Format("2006")
+			year := entry.Modified.UTC().Format("2006")
 			yearSet[year] = true
 		}
 
@@ -1245,8 +1310,9 @@ func (fs *FS) getAvailableSnapshotMonths(year string) []string {
 		}
 
 		for entry := range lookupTable.Iterate {
-			if entry.Modified.Format("2006") == year {
-				month := entry.Modified.Format("01")
+			modified := entry.Modified.UTC()
+			if modified.Format("2006") == year {
+				month := modified.Format("01")
 				monthSet[month] = true
 			}
 		}
@@ -1283,8 +1349,9 @@ func (fs *FS) getAvailableSnapshotDays(year, month string) []string {
 		}
 
 		for entry := range lookupTable.Iterate {
-			if entry.Modified.Format("2006") == year && entry.Modified.Format("01") == month {
-				day := entry.Modified.Format("02")
+			modified := entry.Modified.UTC()
+			if modified.Format("2006") == year && modified.Format("01") == month {
+				day := modified.Format("02")
 				daySet[day] = true
 			}
 		}
@@ -1369,7 +1436,8 @@ func (fs *FS) searchLookupTableAtTime(manifestPath, relativePath string, snapsho
 		if entry.Name == relativePath {
 			// Check if this entry is within the snapshot time
 			if snapshotTime == nil || entry.Modified.Before(*snapshotTime) || entry.Modified.Equal(*snapshotTime) {
-				if latestEntry == nil || entry.Modified.After(latestEntry.Modified) {
+				// Later entries win ties, such as a chmod of the same revision
+				if latestEntry == nil || !entry.Modified.Before(latestEntry.Modified) {
 					entryCopy := entry // Create a copy to avoid pointer issues
 					latestEntry = &entryCopy
 				}
@@ -1377,7 +1445,7 @@ func (fs *FS) searchLookupTableAtTime(manifestPath, relativePath string, snapsho
 		}
 	}
 
-	if latestEntry != nil && latestEntry.Target != "" {
+	if latestEntry != nil && latestEntry.Target != "" && !latestEntry.IsDir() {
 		return latestEntry, nil
 	}
 
@@ -1386,51 +1454,68 @@ func (fs *FS) searchLookupTableAtTime(manifestPath, relativePath string, snapsho
 
 // hasFilesWithPrefixAtTime checks if any files exist with the given prefix at a specific time
 func (fs *FS) hasFilesWithPrefixAtTime(prefix string, snapshotTime *time.Time) bool {
-	// Walk through all lookup tables to find files with this prefix at the snapshot time
-	err := filepath.Walk(fs.StoragePath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Continue walking on errors
-		}
+	entries, err := fs.snapshotEntries(snapshotTime)
+	if err != nil {
+		return false
+	}
 
-		if !strings.HasSuffix(path, "lookups.djfl") {
-			return nil
+	// A recorded directory exists even without files below it
+	if entry, ok := entries[strings.TrimSuffix(prefix, "/")]; ok && entry.IsDir() {
+		return true
+	}
+	for name := range entries {
+		if strings.HasPrefix(name, prefix) {
+			return true
 		}
+	}
+	return false
+}
 
-		lookupTable, err := fs.loadLookupTable(path)
-		if err != nil {
-			return nil // Continue on errors
+// getEntriesWithPrefixAtTime returns entries that start with the given prefix at a specific time
+func (fs *FS) getEntriesWithPrefixAtTime(prefix string, snapshotTime *time.Time) ([]util.LookupEntry, error) {
+	entries, err := fs.snapshotEntries(snapshotTime)
+	if err != nil {
+		return nil, err
+	}
+
+	var matchingEntries []util.LookupEntry
+	for name, entry := range entries {
+		if strings.HasPrefix(name, prefix) {
+			matchingEntries = append(matchingEntries, entry)
 		}
+	}
+	return matchingEntries, nil
+}
 
-		// Track the latest version of each file
-		latestEntries := make(map[string]*util.LookupEntry)
+// snapshotEntries returns the latest version of every file at a specific
+// time, keyed by logical path. Entry names are rewritten to logical paths and
+// deleted files are left out.
+func (fs *FS) snapshotEntries(snapshotTime *time.Time) (map[string]util.LookupEntry, error) {
+	latestEntries := make(map[string]util.LookupEntry)
 
-		for entry := range lookupTable.Iterate {
-			if snapshotTime == nil || entry.Modified.Before(*snapshotTime) || entry.Modified.Equal(*snapshotTime) {
-				if existing, exists := latestEntries[entry.Name]; !exists || entry.Modified.After(existing.Modified) {
-					entryCopy := entry // Create a copy
-					latestEntries[entry.Name] = &entryCopy
-				}
-			}
+	err := fs.walkLookupEntries(func(entry util.LookupEntry) {
+		if snapshotTime != nil && entry.Modified.After(*snapshotTime) {
+			return
 		}
-
-		// Check if any of the latest entries match our prefix and are not deleted
-		for _, entry := range latestEntries {
-			if entry.Target != "" && strings.HasPrefix(entry.Name, prefix) {
-				return fmt.Errorf("found") // Use error to break out of walk
-			}
+		if existing, exists := latestEntries[entry.Name]; !exists || !entry.Modified.Before(existing.Modified) {
+			latestEntries[entry.Name] = entry
 		}
-
-		return nil
 	})
 
-	return err != nil && err.Error() == "found"
-}
+	// Drop deleted files
+	for name, entry := range latestEntries {
+		if entry.Target == "" {
+			delete(latestEntries, name)
+		}
+	}
 
-// getEntriesWithPrefixAtTime returns entries that start with the given prefix at a specific time
-func (fs *FS) getEntriesWithPrefixAtTime(prefix string, snapshotTime *time.Time) ([]util.LookupEntry, error) {
-	var matchingEntries []util.LookupEntry
+	return latestEntries, err
+}
 
-	err := filepath.Walk(fs.StoragePath, func(path string, info os.FileInfo, err error) error {
+// walkLookupEntries calls fn with every entry of every lookup table, its name
+// rewritten to the logical path
+func (fs *FS) walkLookupEntries(fn func(util.LookupEntry)) error {
+	return filepath.Walk(fs.StoragePath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil // Continue walking on errors
 		}
@@ -1444,27 +1529,17 @@ func (fs *FS) getEntriesWithPrefixAtTime(prefix string, snapshotTime *time.Time)
 			return nil // Continue on errors
 		}
 
-		// Track the latest version of each file
-		latestEntries := make(map[string]*util.LookupEntry)
-
-		for entry := range lookupTable.Iterate {
-			if snapshotTime == nil || entry.Modified.Before(*snapshotTime) || entry.Modified.Equal(*snapshotTime) {
-				if existing, exists := latestEntries[entry.Name]; !exists || entry.Modified.After(existing.Modified) {
-					entryCopy := entry // Create a copy
-					latestEntries[entry.Name] = &entryCopy
-				}
-			}
+		// Entry names are relative to the boundary holding the table
+		boundary, err := filepath.Rel(fs.StoragePath, filepath.Dir(path))
+		if err != nil {
+			return nil
 		}
 
-		// Add matching entries that are not deleted
-		for _, entry := range latestEntries {
-			if entry.Target != "" && strings.HasPrefix(entry.Name, prefix) {
-				matchingEntries = append(matchingEntries, *entry)
-			}
+		for entry := range lookupTable.Iterate {
+			entry.Name = filepath.ToSlash(filepath.Join(boundary, entry.Name))
+			fn(entry)
 		}
 
 		return nil
 	})
-
-	return matchingEntries, err
 }
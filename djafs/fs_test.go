@@ -1,7 +1,11 @@
 package djafs
 
 import (
+	"archive/zip"
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -19,7 +23,6 @@ func TestSetattrDeadlockFix(t *testing.T) {
 			Inode:    12345,
 			Modified: time.Now(),
 		},
-		data:     []byte("test data"),
 		modified: time.Now(),
 		isNew:    true,
 	}
@@ -58,14 +61,15 @@ func TestSetattrFunctionality(t *testing.T) {
 			Inode:    12345,
 			Modified: time.Now(),
 		},
-		data:     []byte("test"),
 		modified: time.Now(),
 		isNew:    true,
 	}
+	h := f.newHandle(fuse.OpenReadWrite)
+	h.data, h.loaded = []byte("test"), true
 
 	ctx := context.Background()
 	newTime := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
-	
+
 	// Test mtime update
 	req := &fuse.SetattrRequest{
 		Valid: fuse.SetattrMtime,
@@ -93,11 +97,103 @@ func TestSetattrFunctionality(t *testing.T) {
 		t.Fatalf("Setattr size failed: %v", err)
 	}
 
-	if len(f.data) != 2 {
-		t.Errorf("Expected data length 2, got %d", len(f.data))
+	if len(h.data) != 2 {
+		t.Errorf("Expected data length 2, got %d", len(h.data))
+	}
+
+	if string(h.data) != "te" {
+		t.Errorf("Expected data 'te', got '%s'", string(h.data))
+	}
+}
+
+// TestFindArchiveForTarget_UsesIndex verifies reads go through the target index
+// and that a scan fallback records what it finds
+func TestFindArchiveForTarget_UsesIndex(t *testing.T) {
+	storage := t.TempDir()
+	dataDir := filepath.Join(storage, util.DataDir)
+	os.MkdirAll(dataDir, 0o755)
+
+	target := util.HashPathFromHash(strings.Repeat("ab", 32))
+	archivePath := filepath.Join(dataDir, "1-00000.djfz")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	w := zip.NewWriter(f)
+	fw, _ := w.Create(target)
+	fw.Write([]byte(`{"v":1}`))
+	w.Close()
+	f.Close()
+
+	targets, _ := util.LoadTargetIndex(storage)
+	fsys := &FS{StoragePath: storage, Targets: targets}
+
+	// Not indexed yet: found by scanning and recorded
+	got, err := fsys.findArchiveForTarget(target)
+	if err != nil || got != archivePath {
+		t.Fatalf("findArchiveForTarget = %q, %v; want %q", got, err, archivePath)
+	}
+	reloaded, _ := util.LoadTargetIndex(storage)
+	if indexed, ok := reloaded.Lookup(target); !ok || indexed != archivePath {
+		t.Errorf("Scan result should be persisted, got %q, %v", indexed, ok)
+	}
+
+	// A stale mapping falls back to the correct archive
+	stale := filepath.Join(dataDir, "2-00000.djfz")
+	os.WriteFile(stale, []byte("not a zip"), 0o644)
+	fsys.Targets.Set(target, stale)
+	content, err := fsys.loadFileContent(&util.LookupEntry{Target: target})
+	if err != nil {
+		t.Fatalf("loadFileContent failed: %v", err)
+	}
+	if string(content) != `{"v":1}` {
+		t.Errorf("Unexpected content %q", content)
+	}
+}
+
+func TestUpdateLookupTable_Boundaries(t *testing.T) {
+	storage := t.TempDir()
+	created := time.Now().Add(-time.Hour)
+	writeLookupTable(t, storage,
+		util.LookupEntry{Name: "logs/a.json", Target: "1-00000-aaa", Modified: created},
+	)
+	writeLookupTable(t, filepath.Join(storage, "sensors", "loc1"),
+		util.LookupEntry{Name: "reading.json", Target: "1-00000-bbb", Modified: created},
+	)
+
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	tests := []struct {
+		path     string
+		manifest string // Relative to storage
+		name     string // Entry name in the manifest
+	}{
+		{path: "sensors/loc1/new.json", manifest: "sensors/loc1/lookups.djfl", name: "new.json"},
+		{path: "sensors/loc1/dev/x.json", manifest: "sensors/loc1/lookups.djfl", name: "dev/x.json"},
+		{path: "sensors/y.json", manifest: "lookups.djfl", name: "sensors/y.json"},
+		{path: "logs/b.json", manifest: "lookups.djfl", name: "logs/b.json"},
+		{path: "top.json", manifest: "lookups.djfl", name: "top.json"},
+		{path: "fresh/day/c.json", manifest: "fresh/lookups.djfl", name: "day/c.json"},
 	}
+	for _, tt := range tests {
+		staged := stageFile(t, fsys.HotCache, tt.path, `{"v":1}`)
+		f := &stagedFile{stagingPath: staged, relPath: tt.path}
+		fsys.HotCache.archive([]*stagedFile{f})
+		if f.err != nil {
+			t.Fatalf("archive %s failed: %v", tt.path, f.err)
+		}
 
-	if string(f.data) != "te" {
-		t.Errorf("Expected data 'te', got '%s'", string(f.data))
+		lt, err := util.ReadLookupTable(filepath.Join(storage, tt.manifest))
+		if err != nil {
+			t.Fatalf("ReadLookupTable %s failed: %v", tt.manifest, err)
+		}
+		if last := lt.Get(lt.Len() - 1); last.Name != tt.name {
+			t.Errorf("%s: expected %q last in %s, got %q", tt.path, tt.name, tt.manifest, last.Name)
+		}
+
+		if _, ok := fsys.Index.Lookup(tt.path); !ok {
+			t.Errorf("%s missing from the index", tt.path)
+		}
 	}
-}
\ No newline at end of file
+}
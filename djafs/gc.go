@@ -0,0 +1,276 @@
+package djafs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// stagedFile is a file a collection moved to staging
+type stagedFile struct {
+	stagingPath string
+	relPath     string // Logical path
+	entry       util.LookupEntry
+	owner       util.LookupEntry
+	hasOwner    bool
+	err         error // Why the file is still in staging
+}
+
+// hotUsage is what the hot cache holds
+type hotUsage struct {
+	files  int
+	bytes  int64
+	oldest time.Time // Modification time of the oldest file
+}
+
+// collect packs the hot cache if the trigger policy says it is due and no
+// quiet window is open, and otherwise only moves journaled changes into
+// lookup tables
+func (hc *HotCache) collect() {
+	if hc.due(hc.recount()) && !hc.quiet(time.Now()) {
+		hc.processFiles()
+		return
+	}
+
+	hc.gcMu.Lock()
+	defer hc.gcMu.Unlock()
+	if err := hc.applyPending(); err != nil {
+		fmt.Printf("Error applying hot cache journal: %v\n", err)
+	}
+}
+
+// due reports whether a hot cache holding u should be packed. With every
+// trigger disabled any file is enough.
+func (hc *HotCache) due(u hotUsage) bool {
+	opts := hc.fs.Options
+	switch {
+	case u.files == 0:
+		return false
+	case opts.PackFiles == 0 && opts.PackBytes == 0 && opts.PackAge == 0:
+		return true
+	}
+	return opts.PackFiles > 0 && u.files >= opts.PackFiles ||
+		opts.PackBytes > 0 && u.bytes >= opts.PackBytes ||
+		opts.PackAge > 0 && time.Since(u.oldest) >= opts.PackAge
+}
+
+// quiet reports whether a quiet window is open at t
+func (hc *HotCache) quiet(t time.Time) bool {
+	for _, w := range hc.fs.Options.QuietWindows {
+		if w.Active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkThresholds asks for a collection as soon as the hot cache holds
+// Options.PackFiles files or Options.PackBytes bytes, instead of waiting
+// for the next check
+func (hc *HotCache) checkThresholds() {
+	opts := hc.fs.Options
+	if len(opts.QuietWindows) > 0 && hc.quiet(time.Now()) {
+		return
+	}
+	if opts.PackFiles > 0 && hc.backlog.Load() >= int64(opts.PackFiles) ||
+		opts.PackBytes > 0 && hc.backlogSize.Load() >= opts.PackBytes {
+		select {
+		case hc.trigger <- struct{}{}:
+		default: // A collection was asked for already
+		}
+	}
+}
+
+// usage sums up the files in incoming and staging. It walks without
+// hc.mu, so files written meanwhile may be missed.
+func (hc *HotCache) usage() hotUsage {
+	var u hotUsage
+	for _, dir := range []string{hc.IncomingDir, hc.StagingDir} {
+		filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // Continue on errors
+			}
+			if info.IsDir() {
+				if p == hc.spillPath() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			u.files++
+			u.bytes += info.Size()
+			if u.oldest.IsZero() || info.ModTime().Before(u.oldest) {
+				u.oldest = info.ModTime()
+			}
+			return nil
+		})
+	}
+	return u
+}
+
+// recount corrects the backlog from the files on disk and wakes writers
+// waiting for room
+func (hc *HotCache) recount() hotUsage {
+	u := hc.usage()
+	hc.backlog.Store(int64(u.files))
+	hc.backlogSize.Store(u.bytes)
+	hc.checkHighWater()
+
+	hc.drainMu.Lock()
+	close(hc.drained)
+	hc.drained = make(chan struct{})
+	hc.drainMu.Unlock()
+	return u
+}
+
+// admit waits until the hot cache has room for another file. A full hot
+// cache wakes the garbage collector; if no collection makes room within
+// Options.QueueWait the write fails with EAGAIN.
+func (hc *HotCache) admit() error {
+	limit := int64(hc.fs.Options.QueueFiles)
+	if limit <= 0 || hc.backlog.Load() < limit {
+		return nil
+	}
+
+	timeout := time.NewTimer(hc.fs.Options.QueueWait)
+	defer timeout.Stop()
+	hc.drainMu.Lock()
+	drained := hc.drained
+	hc.drainMu.Unlock()
+	select {
+	case hc.wake <- struct{}{}:
+	default: // A collection was asked for already
+	}
+
+	for {
+		select {
+		case <-drained:
+		case <-timeout.C:
+			return syscall.EAGAIN
+		}
+		if hc.backlog.Load() < limit {
+			return nil
+		}
+		hc.drainMu.Lock()
+		drained = hc.drained
+		hc.drainMu.Unlock()
+	}
+}
+
+// archive runs staged files through the pipeline: Options.GCWorkers copy
+// them into the work directory, which is packed into archives before the
+// entries are appended to their lookup tables, so every recorded file can
+// be read. Files that fail keep their error and stay in staging. The
+// caller must hold hc.gcMu.
+func (hc *HotCache) archive(files []*stagedFile) {
+	if len(files) == 0 {
+		return
+	}
+
+	// A fixed number of workers hash and copy the files
+	workers := min(max(hc.fs.Options.GCWorkers, 1), len(files))
+	queue := make(chan *stagedFile, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for range workers {
+		go func() {
+			defer wg.Done()
+			for f := range queue {
+				f.entry, f.err = hc.ingest(f.stagingPath, f.relPath)
+			}
+		}()
+	}
+	for _, f := range files {
+		queue <- f
+	}
+	close(queue)
+	wg.Wait()
+
+	// Entries may only point at archived content, which pack leaves synced
+	hc.pack()
+
+	var entries []util.LookupEntry
+	var packed []*stagedFile
+	for _, f := range files {
+		if f.err != nil {
+			continue
+		}
+		if _, err := os.Stat(hc.workPath(f.entry.Target)); err == nil {
+			f.err = fmt.Errorf("failed to pack %s", f.entry.Target)
+			continue
+		}
+		f.owner, f.hasOwner = hc.Owner(f.relPath)
+		if f.hasOwner {
+			copyOwner(&f.entry, f.owner)
+		}
+		entries = append(entries, f.entry)
+		packed = append(packed, f)
+	}
+
+	if err := hc.updateLookupTable(entries...); err != nil {
+		for _, f := range packed {
+			f.err = fmt.Errorf("failed to update lookup table: %w", err)
+		}
+		return
+	}
+	for _, f := range packed {
+		hc.finishFile(f.stagingPath, f.relPath, f.owner, f.hasOwner)
+	}
+}
+
+// pack packs the work directory into archives below util.DataDir and
+// removes what it packed
+func (hc *HotCache) pack() {
+	workDir := filepath.Join(hc.fs.StoragePath, util.WorkDir)
+	dirents, err := os.ReadDir(workDir)
+	if err != nil {
+		return
+	}
+
+	// Earlier versions copied content straight into the work directory.
+	// Copies that don't match their hash were interrupted.
+	for _, d := range dirents {
+		if d.IsDir() {
+			continue
+		}
+		loose := filepath.Join(workDir, d.Name())
+		hash, err := util.GetFileHash(loose)
+		if err != nil || util.HashPathFromHash(hash) != d.Name() || isFile(hc.workPath(d.Name())) {
+			os.Remove(loose)
+			continue
+		}
+		workPath := hc.workPath(d.Name())
+		if err := os.MkdirAll(filepath.Dir(workPath), 0755); err == nil {
+			os.Rename(loose, workPath)
+		}
+	}
+
+	buckets, _ := util.ListWorkDirs(workDir)
+	if err := util.GCWorkDirs(workDir); err != nil {
+		fmt.Printf("Error packing work directory: %v\n", err)
+	}
+
+	// Close pooled readers of the archives the buckets replaced
+	dataDir := filepath.Join(hc.fs.StoragePath, util.DataDir)
+	for _, bucket := range buckets {
+		hc.fs.pool.Invalidate(util.WorkDirPathToZipPath(bucket, workDir, dataDir))
+	}
+
+	// GCWorkDirs removes the packed buckets, but not the directories above
+	dirents, _ = os.ReadDir(workDir)
+	for _, d := range dirents {
+		if d.IsDir() {
+			os.Remove(filepath.Join(workDir, d.Name()))
+		}
+	}
+}
+
+// workPath returns where ingest copies the content of target
+func (hc *HotCache) workPath(target string) string {
+	prefix, _ := util.WorkspacePrefixFromHashPath(target)
+	return filepath.Join(hc.fs.StoragePath, util.WorkDir, prefix, target)
+}
@@ -0,0 +1,244 @@
+package djafs
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+func TestProcessFiles_PacksArchives(t *testing.T) {
+	storage := t.TempDir()
+	writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "old.json", Target: "1-00000-aaa", FileSize: 3, Modified: time.Now().Add(-time.Hour)},
+	)
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	files := map[string]string{
+		"sensors/a.json": `{"v":1}`,
+		"fresh/b.json":   `{"v":2}`,
+	}
+	for p, content := range files {
+		if err := fsys.HotCache.WriteFile(p, []byte(content)); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+	}
+	fsys.HotCache.processFiles()
+
+	// Nothing is left behind in the hot cache or the work directory
+	for _, dir := range []string{fsys.HotCache.IncomingDir, fsys.HotCache.StagingDir, filepath.Join(storage, util.WorkDir)} {
+		filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				t.Errorf("Expected %s to be cleaned up", p)
+			}
+			return nil
+		})
+	}
+	if archives, _ := filepath.Glob(filepath.Join(storage, util.DataDir, "*.djfz")); len(archives) == 0 {
+		t.Error("Expected the content to be packed into archives")
+	}
+
+	// Every file is read back from its archive
+	for p, content := range files {
+		if _, ok := fsys.Index.Lookup(p); !ok {
+			t.Errorf("%s missing from the index", p)
+		}
+		node, err := lookupPath(fsys, "/live/"+p)
+		if err != nil {
+			t.Fatalf("Lookup %s failed: %v", p, err)
+		}
+		if node.(*File).isHot {
+			t.Errorf("Expected %s to be archived", p)
+		}
+		if got := readNode(t, node); got != content {
+			t.Errorf("Read %q from %s, want %q", got, p, content)
+		}
+	}
+
+	// The boundary metadata counts the new file
+	data, err := os.ReadFile(filepath.Join(storage, "sensors", "metadata.djfm"))
+	if err != nil {
+		t.Fatalf("Reading the boundary metadata failed: %v", err)
+	}
+	var metadata util.Metadata
+	json.Unmarshal(data, &metadata)
+	if metadata.TotalFileCount != 2 {
+		t.Errorf("Expected 2 files in the boundary metadata, got %d", metadata.TotalFileCount)
+	}
+}
+
+func TestCollect_TriggerPolicy(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PackFiles = 10
+	opts.PackBytes = 1 << 20
+	opts.PackAge = time.Hour
+	hc := &HotCache{fs: &FS{Options: opts}}
+
+	recent := time.Now()
+	tests := []struct {
+		name  string
+		usage hotUsage
+		want  bool
+	}{
+		{name: "empty", usage: hotUsage{}, want: false},
+		{name: "below every trigger", usage: hotUsage{files: 9, bytes: 1 << 19, oldest: recent}, want: false},
+		{name: "file count", usage: hotUsage{files: 10, bytes: 10, oldest: recent}, want: true},
+		{name: "bytes", usage: hotUsage{files: 1, bytes: 1 << 20, oldest: recent}, want: true},
+		{name: "age", usage: hotUsage{files: 1, bytes: 10, oldest: recent.Add(-2 * time.Hour)}, want: true},
+	}
+	for _, tt := range tests {
+		if got := hc.due(tt.usage); got != tt.want {
+			t.Errorf("%s: due = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Without triggers every collection packs
+	hc.fs.Options.PackFiles, hc.fs.Options.PackBytes, hc.fs.Options.PackAge = 0, 0, 0
+	if !hc.due(hotUsage{files: 1, oldest: recent}) {
+		t.Error("Expected any file to be packed without triggers")
+	}
+}
+
+func TestWriteFile_Backpressure(t *testing.T) {
+	opts := DefaultOptions()
+	opts.QueueFiles = 2
+	opts.QueueWait = time.Second
+	fsys := NewFSWithOptions(t.TempDir(), opts)
+	defer fsys.Stop()
+	hc := fsys.HotCache
+
+	hc.WriteFile("a.json", []byte("a"))
+	hc.WriteFile("b.json", []byte("b"))
+
+	// A full hot cache makes the garbage collector archive the waiting files
+	if err := hc.WriteFile("c.json", []byte("c")); err != nil {
+		t.Fatalf("Expected the write to wait for room, got %v", err)
+	}
+	if _, ok := fsys.Index.Lookup("a.json"); !ok {
+		t.Error("Expected the waiting files to be archived")
+	}
+}
+
+func TestWriteFile_BackpressureTimesOut(t *testing.T) {
+	storage := t.TempDir()
+	opts := DefaultOptions()
+	opts.QueueFiles = 2
+	opts.QueueWait = 100 * time.Millisecond
+	fsys := NewFSWithOptions(storage, opts)
+	defer fsys.Stop()
+	hc := fsys.HotCache
+
+	// Nothing can be archived
+	os.WriteFile(filepath.Join(storage, util.WorkDir), nil, 0o644)
+	hc.WriteFile("a.json", []byte("a"))
+	hc.WriteFile("b.json", []byte("b"))
+
+	if err := hc.WriteFile("c.json", []byte("c")); fuse.ToErrno(err) != fuse.Errno(syscall.EAGAIN) {
+		t.Errorf("Expected EAGAIN, got %v", err)
+	}
+	if _, ok := hc.Stat("c.json"); ok {
+		t.Error("A rejected write should not reach the hot cache")
+	}
+
+	// Replacing a waiting file takes no room
+	if err := hc.WriteFile("a.json", []byte("a2")); err != nil {
+		t.Errorf("Replacing a file failed: %v", err)
+	}
+}
+
+func TestCollect_QuietWindowsAndMinAge(t *testing.T) {
+	opts := DefaultOptions()
+	opts.PackFiles, opts.PackBytes, opts.PackAge = 0, 0, 0
+	always, _ := ParseQuietWindow("* * * * * 1m")
+	opts.QuietWindows = []QuietWindow{always}
+	fsys := NewFSWithOptions(t.TempDir(), opts)
+	defer fsys.Stop()
+	hc := fsys.HotCache
+
+	hc.WriteFile("a.json", []byte("a"))
+	hc.collect()
+	if _, ok := fsys.Index.Lookup("a.json"); ok {
+		t.Error("Packing should be deferred during a quiet window")
+	}
+
+	fsys.Options.QuietWindows = nil
+	fsys.Options.MinPackAge = time.Hour
+	hc.collect()
+	if _, ok := fsys.Index.Lookup("a.json"); ok {
+		t.Error("Files younger than MinPackAge should stay in the hot cache")
+	}
+
+	fsys.Options.MinPackAge = 0
+	hc.collect()
+	if _, ok := fsys.Index.Lookup("a.json"); !ok {
+		t.Error("Expected the file to be packed")
+	}
+}
+
+func TestCollect_ThresholdsPackImmediately(t *testing.T) {
+	opts := DefaultOptions()
+	opts.GCInterval = time.Hour
+	opts.PackFiles = 2
+	fsys := NewFSWithOptions(t.TempDir(), opts)
+	defer fsys.Stop()
+
+	fsys.HotCache.WriteFile("a.json", []byte("a"))
+	fsys.HotCache.WriteFile("b.json", []byte("b"))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, a := fsys.Index.Lookup("a.json")
+		_, b := fsys.Index.Lookup("b.json")
+		if a && b {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected crossing PackFiles to pack without waiting for GCInterval")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPack_ClosesReplacedArchives(t *testing.T) {
+	storage := t.TempDir()
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	// An idle pooled reader of the archive the new file is packed into
+	content := []byte(`{"v":1}`)
+	src := filepath.Join(t.TempDir(), "a.json")
+	os.WriteFile(src, content, 0o644)
+	hash, err := util.GetFileHash(src)
+	if err != nil {
+		t.Fatalf("GetFileHash failed: %v", err)
+	}
+	prefix, _ := util.ZipPrefixFromHashPath(util.HashPathFromHash(hash))
+	archivePath := filepath.Join(storage, util.DataDir, prefix+".djfz")
+	os.MkdirAll(filepath.Dir(archivePath), 0o755)
+	writeTestArchive(t, archivePath, map[string][]byte{"old": []byte("old")}, zip.Store)
+	a, err := fsys.pool.Acquire(archivePath)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	old := a
+	fsys.pool.Release(a)
+
+	if err := fsys.HotCache.WriteFile("a.json", content); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	fsys.HotCache.processFiles()
+
+	if fsys.Stats().OpenArchives != 0 {
+		t.Errorf("Expected the replaced archive to leave the pool, %d open", fsys.Stats().OpenArchives)
+	}
+	if _, err := old.raw.Stat(); err == nil {
+		t.Error("The reader of the replaced archive should be closed")
+	}
+}
@@ -0,0 +1,452 @@
+package djafs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fuseutil"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// FileHandle is an open file. Every open gets its own, so two writers never
+// share a buffer: the first write or truncation copies the stored content
+// into data, or into spill once it outgrows Options.WriteBufferBytes.
+type FileHandle struct {
+	file      *File
+	writable  bool          // Opened for writing
+	append    bool          // O_APPEND: every write goes to the end
+	data      []byte        // Private copy of the content once loaded
+	spill     *os.File      // Temporary file holding the content instead of data
+	spillSize int64         // Size of the content in spill
+	loaded    bool          // True once data holds the content
+	dirty     bool          // True while data has changes not flushed yet
+	reader    *memberReader // Open archive member for archived files
+	hot       *os.File      // Open hot cache copy for files not archived yet
+	mu        sync.Mutex
+}
+
+// newHandle opens a handle on f with the access mode of flags
+func (f *File) newHandle(flags fuse.OpenFlags) *FileHandle {
+	h := &FileHandle{
+		file:     f,
+		writable: !flags.IsReadOnly(),
+		append:   flags&fuse.OpenAppend != 0,
+	}
+	f.mu.Lock()
+	if f.handles == nil {
+		f.handles = make(map[*FileHandle]bool)
+	}
+	f.handles[h] = true
+	f.mu.Unlock()
+	return h
+}
+
+// openHandles returns the open handles of f. Handles are locked before
+// their file, so callers lock them only after this returns.
+func (f *File) openHandles() []*FileHandle {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	handles := make([]*FileHandle, 0, len(f.handles))
+	for h := range f.handles {
+		handles = append(handles, h)
+	}
+	return handles
+}
+
+// source returns the stored version of the file, with the hot cache copy
+// opened if it isn't archived yet. ok is false for new files never flushed.
+func (f *File) source() (entry util.LookupEntry, hot *os.File, ok bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.entry == nil {
+		return util.LookupEntry{}, nil, false, nil
+	}
+	if f.isHot {
+		hot, err := f.fs.HotCache.Open(f.entry.Name)
+		if err == nil {
+			return *f.entry, hot, true, nil
+		}
+		archived, found := f.fs.Index.Lookup(f.entry.Name)
+		if !found {
+			return util.LookupEntry{}, nil, false, err
+		}
+		// Archived since it was looked up
+		f.entry = &archived
+		f.isHot = false
+	}
+	return *f.entry, nil, true, nil
+}
+
+// written records the size of a handle's unflushed changes for Attr
+func (f *File) written(size int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.size = size
+	f.modified = time.Now()
+	f.isNew = true
+}
+
+// flushed points f at the hot cache copy written at logical path p
+func (f *File) flushed(p string, size int64, owner util.LookupEntry) {
+	entry := util.LookupEntry{FileSize: size, Modified: time.Now(), Name: p}
+	if hf, ok := f.fs.HotCache.Stat(p); ok {
+		entry = hotEntry(p, hf)
+	}
+	copyOwner(&entry, owner)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.entry != nil {
+		entry.Inode = f.entry.Inode
+	}
+	f.entry = &entry
+	f.isHot = true
+	f.isNew = false
+}
+
+// Read reads the requested range of the file, streaming archived content
+// from its archive member instead of loading the whole file
+func (h *FileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	h.mu.Lock()
+	if h.loaded {
+		defer h.mu.Unlock()
+		if h.spill != nil {
+			return readAt(h.spill, req, resp)
+		}
+		fuseutil.HandleRead(req, resp, h.data)
+		return nil
+	}
+
+	if h.hot == nil && h.reader == nil {
+		entry, hot, ok, err := h.file.source()
+		if err != nil || !ok {
+			// New files not flushed by another handle yet are empty
+			h.mu.Unlock()
+			return err
+		}
+		if hot != nil {
+			h.hot = hot
+		} else if err := h.openArchived(&entry, req, resp); err != nil || h.reader == nil {
+			// Deleted revisions and cached content are done here
+			h.mu.Unlock()
+			return err
+		}
+	}
+
+	var r io.ReaderAt = h.reader
+	if h.hot != nil {
+		r = h.hot
+	}
+	h.mu.Unlock()
+	return readAt(r, req, resp)
+}
+
+// openArchived serves small files from the content cache and opens the
+// archive member of larger ones in h.reader. The caller must hold h.mu.
+func (h *FileHandle) openArchived(entry *util.LookupEntry, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	fs := h.file.fs
+
+	// Deleted revisions under /versions have no content
+	if entry.Target == "" {
+		return nil
+	}
+
+	// Small files are served from the content cache
+	if content, ok := fs.Content.Get(entry.Target); ok {
+		fuseutil.HandleRead(req, resp, content)
+		return nil
+	}
+	if fs.cacheable(entry.FileSize) {
+		content, err := fs.loadFileContent(entry)
+		if err != nil {
+			return err
+		}
+		fs.Content.Add(entry.Target, content, int64(len(content)))
+		fuseutil.HandleRead(req, resp, content)
+		return nil
+	}
+
+	reader, err := fs.openMember(entry)
+	if err != nil {
+		return err
+	}
+	h.reader = reader
+	return nil
+}
+
+// readAt serves a read request from r
+func readAt(r io.ReaderAt, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	buf := resp.Data[:req.Size]
+	n, err := r.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	resp.Data = buf[:n]
+	return nil
+}
+
+// spills reports whether content of size bytes is kept in a temporary file
+// rather than in memory
+func (h *FileHandle) spills(size int64) bool {
+	fs := h.file.fs
+	return fs != nil && fs.HotCache != nil && size > fs.Options.WriteBufferBytes
+}
+
+// length returns the size of the handle's content. The caller must hold h.mu.
+func (h *FileHandle) length() int64 {
+	if h.spill != nil {
+		return h.spillSize
+	}
+	return int64(len(h.data))
+}
+
+// spillFrom moves the handle's content into a new temporary file, copying it
+// from r. The caller must hold h.mu.
+func (h *FileHandle) spillFrom(r io.Reader) error {
+	tmp, err := h.file.fs.HotCache.CreateTemp()
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = h.file.fs.HotCache.spillGrew(n)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	h.spill, h.spillSize = tmp, n
+	h.data = nil
+	return nil
+}
+
+// dropSpill closes and removes the temporary file. The caller must hold h.mu.
+func (h *FileHandle) dropSpill() {
+	if h.spill == nil {
+		return
+	}
+	h.spill.Close()
+	os.Remove(h.spill.Name())
+	h.file.fs.HotCache.spillGrew(-h.spillSize)
+	h.spill, h.spillSize = nil, 0
+}
+
+// load copies the stored content into the handle's buffer, or its temporary
+// file if it is too large, before its first change. The caller must hold h.mu.
+func (h *FileHandle) load() error {
+	if h.loaded {
+		return nil
+	}
+
+	entry, hot, ok, err := h.file.source()
+	if err != nil {
+		return err
+	}
+	var data []byte
+	switch {
+	case hot != nil:
+		defer hot.Close()
+		var info os.FileInfo
+		if info, err = hot.Stat(); err == nil && h.spills(info.Size()) {
+			err = h.spillFrom(hot)
+		} else if err == nil {
+			data, err = io.ReadAll(hot)
+		}
+	case ok && entry.Target != "" && h.spills(entry.FileSize):
+		var reader *memberReader
+		if reader, err = h.file.fs.openMember(&entry); err == nil {
+			err = h.spillFrom(io.NewSectionReader(reader, 0, reader.Size()))
+			reader.Close()
+		}
+	case ok && entry.Target != "":
+		data, err = h.file.fs.loadFileContent(&entry)
+	}
+	if err != nil {
+		return err
+	}
+
+	if h.spill == nil {
+		h.data = data
+	}
+	h.loaded = true
+	return nil
+}
+
+// reserve spills the in-memory content once it is to grow to size bytes
+// beyond the write buffer. The caller must hold h.mu.
+func (h *FileHandle) reserve(size int64) error {
+	if h.spill != nil || !h.spills(size) {
+		return nil
+	}
+	return h.spillFrom(bytes.NewReader(h.data))
+}
+
+// resize truncates or zero-extends the handle's content. The caller must
+// hold h.mu.
+func (h *FileHandle) resize(size uint64) error {
+	if size == 0 {
+		// Nothing to keep from the stored content
+		h.dropSpill()
+		h.data, h.loaded = []byte{}, true
+	} else if err := h.load(); err != nil {
+		return err
+	}
+	if err := h.reserve(int64(size)); err != nil {
+		return err
+	}
+
+	if h.spill != nil {
+		if err := h.file.fs.HotCache.spillGrew(int64(size) - h.spillSize); err != nil {
+			return err
+		}
+		if err := h.spill.Truncate(int64(size)); err != nil {
+			h.file.fs.HotCache.spillGrew(h.spillSize - int64(size))
+			return err
+		}
+		h.spillSize = int64(size)
+	} else if size < uint64(len(h.data)) {
+		h.data = h.data[:size]
+	} else if size > uint64(len(h.data)) {
+		newData := make([]byte, size)
+		copy(newData, h.data)
+		h.data = newData
+	}
+	h.dirty = true
+	h.file.written(h.length())
+	return nil
+}
+
+// Write writes data to the handle's copy of the file
+func (h *FileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	if h.file.fs.readOnly() {
+		return syscall.EROFS
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.load(); err != nil {
+		return err
+	}
+
+	offset := req.Offset
+	if h.append {
+		offset = h.length()
+	}
+	newLen := offset + int64(len(req.Data))
+	if err := h.reserve(newLen); err != nil {
+		return err
+	}
+
+	if h.spill != nil {
+		// Growth counts against the quota before it reaches the disk
+		grown := max(newLen-h.spillSize, 0)
+		if err := h.file.fs.HotCache.spillGrew(grown); err != nil {
+			return err
+		}
+		if _, err := h.spill.WriteAt(req.Data, offset); err != nil {
+			h.file.fs.HotCache.spillGrew(-grown)
+			return err
+		}
+		h.spillSize = max(h.spillSize, newLen)
+	} else {
+		// Extend data slice if necessary
+		if newLen > int64(len(h.data)) {
+			newData := make([]byte, newLen)
+			copy(newData, h.data)
+			h.data = newData
+		}
+
+		// Write the data
+		copy(h.data[offset:], req.Data)
+	}
+	resp.Size = len(req.Data)
+
+	h.dirty = true
+	h.file.written(h.length())
+	return nil
+}
+
+// Flush writes the handle's changes to the hot cache. Spilled content is
+// renamed into place, so it is never copied.
+func (h *FileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	_, err := h.flush(h.file.fs.Options.SyncOnFlush)
+	return err
+}
+
+// flush writes the handle's changes to the hot cache, on stable storage
+// when durable, and reports whether there were any
+func (h *FileHandle) flush(durable bool) (bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.dirty {
+		return false, nil
+	}
+
+	f := h.file
+	f.mu.RLock()
+	p := f.logicalPath()
+	owner := f.ownerEntry()
+	f.mu.RUnlock()
+
+	// Write to hot cache
+	size := h.length()
+	if h.spill != nil {
+		// Commit counts the content against the quota as part of the hot
+		// cache instead
+		f.fs.HotCache.spillGrew(-size)
+		if err := f.fs.HotCache.Commit(p, h.spill, durable); err != nil {
+			f.fs.HotCache.spilled.Add(size)
+			return false, err
+		}
+		// The file belongs to the hot cache now, later writes start over
+		// from it
+		h.spill.Close()
+		h.spill, h.spillSize = nil, 0
+		h.loaded = false
+	} else if err := f.fs.HotCache.writeFile(p, h.data, durable); err != nil {
+		return false, err
+	}
+	// The new revision keeps the mode and owner of the file
+	if owner.HasOwner() {
+		if err := f.fs.HotCache.SetOwner(p, owner); err != nil {
+			return false, err
+		}
+	}
+
+	h.dirty = false
+	f.flushed(p, size, owner)
+	return true, nil
+}
+
+// Release closes the archive member or hot cache copy once the file is
+// closed, and drops changes that were never flushed
+func (h *FileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.file.mu.Lock()
+	delete(h.file.handles, h)
+	h.file.mu.Unlock()
+
+	h.data = nil
+	h.dropSpill()
+	if h.hot != nil {
+		h.hot.Close()
+		h.hot = nil
+	}
+	if h.reader == nil {
+		return nil
+	}
+	err := h.reader.Close()
+	h.reader = nil
+	return err
+}
@@ -0,0 +1,208 @@
+package djafs
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// openHandle opens a handle on the file at mount path p
+func openHandle(t *testing.T, fsys *FS, p string, flags fuse.OpenFlags) *FileHandle {
+	t.Helper()
+	node, err := lookupPath(fsys, p)
+	if err != nil {
+		t.Fatalf("Lookup %s failed: %v", p, err)
+	}
+	h, err := node.(*File).Open(context.Background(), &fuse.OpenRequest{Flags: flags}, &fuse.OpenResponse{})
+	if err != nil {
+		t.Fatalf("Open %s failed: %v", p, err)
+	}
+	return h.(*FileHandle)
+}
+
+// writeHandle writes data at offset through h
+func writeHandle(t *testing.T, h *FileHandle, offset int64, data string) {
+	t.Helper()
+	req := &fuse.WriteRequest{Offset: offset, Data: []byte(data)}
+	if err := h.Write(context.Background(), req, &fuse.WriteResponse{}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+}
+
+// closeHandle flushes and releases h like close(2)
+func closeHandle(t *testing.T, h *FileHandle) {
+	t.Helper()
+	ctx := context.Background()
+	if err := h.Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	h.Release(ctx, &fuse.ReleaseRequest{})
+}
+
+// hotContent returns what the hot cache holds at logical path p
+func hotContent(t *testing.T, fsys *FS, p string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(fsys.HotCache.IncomingDir, p))
+	if err != nil {
+		t.Fatalf("Reading %s from the hot cache failed: %v", p, err)
+	}
+	return string(data)
+}
+
+// archivedFS returns a filesystem holding sensors/a.json with content
+func archivedFS(t *testing.T, content string) *FS {
+	t.Helper()
+	storage := t.TempDir()
+	target := util.HashPathFromHash(fmt.Sprintf("%064x", 1))
+	writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "a.json", Target: target, FileSize: int64(len(content)), Modified: time.Now().Add(-time.Hour)},
+	)
+	writeTestArchive(t, filepath.Join(storage, "sensors", "1-00000.djfz"), map[string][]byte{target: []byte(content)}, zip.Deflate)
+	fsys := NewFS(storage)
+	t.Cleanup(fsys.Stop)
+	return fsys
+}
+
+func TestHandle_PartialOverwriteKeepsContent(t *testing.T) {
+	fsys := archivedFS(t, "0123456789")
+
+	h := openHandle(t, fsys, "/live/sensors/a.json", fuse.OpenReadWrite)
+	writeHandle(t, h, 2, "ab")
+	closeHandle(t, h)
+
+	if got := hotContent(t, fsys, "sensors/a.json"); got != "01ab456789" {
+		t.Errorf("Expected the archived content around the write, got %q", got)
+	}
+	node, _ := lookupPath(fsys, "/live/sensors/a.json")
+	if got := readNode(t, node); got != "01ab456789" {
+		t.Errorf("Read back %q", got)
+	}
+}
+
+func TestHandle_WritersDontShareBuffers(t *testing.T) {
+	fsys := archivedFS(t, "0123456789")
+
+	first := openHandle(t, fsys, "/live/sensors/a.json", fuse.OpenReadWrite)
+	second := openHandle(t, fsys, "/live/sensors/a.json", fuse.OpenReadWrite)
+	reader := openHandle(t, fsys, "/live/sensors/a.json", fuse.OpenReadOnly)
+	writeHandle(t, first, 0, "AA")
+	writeHandle(t, second, 8, "ZZ")
+
+	// Unflushed writes are private to their handle
+	req := &fuse.ReadRequest{Size: 64}
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, req.Size)}
+	if err := reader.Read(context.Background(), req, resp); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(resp.Data) != "0123456789" {
+		t.Errorf("Reader saw unflushed writes: %q", resp.Data)
+	}
+	reader.Release(context.Background(), &fuse.ReleaseRequest{})
+
+	closeHandle(t, first)
+	if got := hotContent(t, fsys, "sensors/a.json"); got != "AA23456789" {
+		t.Errorf("First close stored %q", got)
+	}
+	// The last close wins as a whole instead of mixing both writers
+	closeHandle(t, second)
+	if got := hotContent(t, fsys, "sensors/a.json"); got != "01234567ZZ" {
+		t.Errorf("Second close stored %q", got)
+	}
+}
+
+func TestHandle_TruncateAndAppend(t *testing.T) {
+	fsys := archivedFS(t, "0123456789")
+
+	h := openHandle(t, fsys, "/live/sensors/a.json", fuse.OpenWriteOnly|fuse.OpenTruncate)
+	writeHandle(t, h, 0, "new")
+	closeHandle(t, h)
+	if got := hotContent(t, fsys, "sensors/a.json"); got != "new" {
+		t.Errorf("Expected O_TRUNC to drop the old content, got %q", got)
+	}
+
+	h = openHandle(t, fsys, "/live/sensors/a.json", fuse.OpenWriteOnly|fuse.OpenAppend)
+	writeHandle(t, h, 0, "+tail")
+	closeHandle(t, h)
+	if got := hotContent(t, fsys, "sensors/a.json"); got != "new+tail" {
+		t.Errorf("Expected O_APPEND to write at the end, got %q", got)
+	}
+
+	// truncate(2) without an open handle stores the result directly
+	node, _ := lookupPath(fsys, "/live/sensors/a.json")
+	req := &fuse.SetattrRequest{Valid: fuse.SetattrSize, Size: 2}
+	resp := &fuse.SetattrResponse{}
+	if err := node.(*File).Setattr(context.Background(), req, resp); err != nil {
+		t.Fatalf("Setattr failed: %v", err)
+	}
+	if resp.Attr.Size != 2 {
+		t.Errorf("Expected size 2, got %d", resp.Attr.Size)
+	}
+	if got := hotContent(t, fsys, "sensors/a.json"); got != "ne" {
+		t.Errorf("Expected the truncated content, got %q", got)
+	}
+}
+
+func TestHandle_SpillsLargeWrites(t *testing.T) {
+	storage := t.TempDir()
+	opts := DefaultOptions()
+	opts.WriteBufferBytes = 4
+	fsys := NewFSWithOptions(storage, opts)
+	defer fsys.Stop()
+
+	ctx := context.Background()
+	live := &Dir{fs: fsys, path: "/live"}
+	_, handle, err := live.Create(ctx, &fuse.CreateRequest{Name: "big.json", Flags: fuse.OpenReadWrite}, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	h := handle.(*FileHandle)
+	writeHandle(t, h, 0, "012")
+	if h.spill != nil {
+		t.Error("Writes within the buffer should stay in memory")
+	}
+	writeHandle(t, h, 3, "3456789")
+	if h.spill == nil || h.data != nil {
+		t.Fatal("Expected the content to move to a temporary file")
+	}
+
+	// Files being written are invisible to /live and the garbage collector
+	if dirents, _ := live.ReadDirAll(ctx); len(dirents) != 0 {
+		t.Errorf("Expected an empty /live, got %+v", dirents)
+	}
+	fsys.HotCache.processFiles()
+	if _, err := os.Stat(h.spill.Name()); err != nil {
+		t.Errorf("The garbage collector touched a file being written: %v", err)
+	}
+
+	req := &fuse.ReadRequest{Offset: 2, Size: 4}
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, req.Size)}
+	if err := h.Read(ctx, req, resp); err != nil || string(resp.Data) != "2345" {
+		t.Errorf("Read %q, %v from the temporary file", resp.Data, err)
+	}
+
+	closeHandle(t, h)
+	if got := hotContent(t, fsys, "big.json"); got != "0123456789" {
+		t.Errorf("Flush stored %q", got)
+	}
+	if entries, _ := os.ReadDir(fsys.HotCache.spillPath()); len(entries) != 0 {
+		t.Errorf("Flush should move the temporary file, found %d left", len(entries))
+	}
+
+	// A later partial write starts from the flushed copy
+	h = openHandle(t, fsys, "/live/big.json", fuse.OpenReadWrite)
+	writeHandle(t, h, 9, "X")
+	if h.spill == nil {
+		t.Error("Expected large stored content to load into a temporary file")
+	}
+	closeHandle(t, h)
+	if got := hotContent(t, fsys, "big.json"); got != "012345678X" {
+		t.Errorf("Second flush stored %q", got)
+	}
+}
@@ -0,0 +1,578 @@
+package djafs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// PathIndexFile is the name of the persisted path index, stored at the root
+// of the storage directory.
+const PathIndexFile = "paths.djfi"
+
+// PathIndex is a persistent, incrementally maintained index of the /live view.
+//
+// For every lookup table under the storage directory it records the newest
+// entry per name, keyed by the manifest's path relative to storage. From that
+// it derives the current entry for each logical path and the children of
+// each logical directory, so Lookup and ReadDirAll under /live never walk the
+// storage tree or decode lookup tables.
+type PathIndex struct {
+	Manifests map[string]*IndexedManifest `json:"manifests"`
+
+	storagePath string
+	live        map[string]indexedEntry     // logical path -> newest entry across manifests
+	owners      map[string][]string         // logical path -> manifests holding an entry for it
+	children    map[string]map[string]int   // logical dir -> child name -> live paths below it
+	pending     map[string]util.LookupEntry // logical path -> change not yet in a lookup table
+	liveBytes   int64                       // Size of the live files
+	liveFiles   int64
+	dirty       bool
+	mu          sync.RWMutex
+}
+
+// IndexedManifest is the indexed state of a single lookups.djfl file.
+// ModTime and Size are used to detect manifests changed outside the mount.
+type IndexedManifest struct {
+	ModTime  time.Time                   `json:"mod_time"`
+	Size     int64                       `json:"size"`
+	Entries  map[string]util.LookupEntry `json:"entries"`   // newest entry per boundary-relative name, tombstones included
+	MaxInode uint64                      `json:"max_inode"` // highest inode of any entry, older versions included
+}
+
+// IndexDirent is a single child of an indexed directory.
+type IndexDirent struct {
+	Name  string
+	IsDir bool
+	Entry util.LookupEntry // set for files only
+}
+
+type indexedEntry struct {
+	entry    util.LookupEntry
+	manifest string // empty for pending changes
+}
+
+// LoadPathIndex loads the persisted path index for storagePath.
+// A missing or unreadable index yields an empty one; call Sync to bring it
+// up to date with the lookup tables on disk.
+func LoadPathIndex(storagePath string) *PathIndex {
+	idx := &PathIndex{storagePath: storagePath}
+
+	f, err := os.Open(filepath.Join(storagePath, PathIndexFile))
+	if err == nil {
+		if err := json.NewDecoder(f).Decode(idx); err != nil {
+			fmt.Printf("Ignoring unreadable path index: %v\n", err)
+			idx.Manifests = nil
+		}
+		f.Close()
+	}
+	if idx.Manifests == nil {
+		idx.Manifests = make(map[string]*IndexedManifest)
+		idx.dirty = true
+	}
+
+	idx.rebuild()
+	return idx
+}
+
+// Sync reconciles the index with the lookup tables on disk. It only stats
+// each manifest; tables are decoded again only when new or changed, and
+// manifests that disappeared are dropped.
+func (idx *PathIndex) Sync() error {
+	seen := make(map[string]bool)
+
+	err := filepath.WalkDir(idx.storagePath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // Continue walking on errors
+		}
+
+		if d.IsDir() && p != idx.storagePath {
+			switch d.Name() {
+			case "hot_cache", util.WorkDir:
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Name() != "lookups.djfl" {
+			return nil
+		}
+
+		key, err := filepath.Rel(idx.storagePath, p)
+		if err != nil {
+			return nil
+		}
+		seen[key] = true
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		idx.mu.RLock()
+		m, ok := idx.Manifests[key]
+		fresh := ok && m.Size == info.Size() && m.ModTime.Equal(info.ModTime())
+		idx.mu.RUnlock()
+		if fresh {
+			return nil
+		}
+
+		if err := idx.Refresh(p); err != nil {
+			fmt.Printf("Error indexing %s: %v\n", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for key := range idx.Manifests {
+		if !seen[key] {
+			idx.dropManifest(key)
+		}
+	}
+
+	return nil
+}
+
+// Refresh re-reads a single lookup table and updates the index with its
+// contents. manifestPath is the absolute path of the lookups.djfl file.
+func (idx *PathIndex) Refresh(manifestPath string) error {
+	key, err := filepath.Rel(idx.storagePath, manifestPath)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(manifestPath)
+	if os.IsNotExist(err) {
+		idx.mu.Lock()
+		idx.dropManifest(key)
+		idx.mu.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lookupTable, err := util.ReadLookupTable(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	m := &IndexedManifest{
+		ModTime: info.ModTime(),
+		Size:    info.Size(),
+		Entries: make(map[string]util.LookupEntry),
+	}
+	for entry := range lookupTable.Iterate {
+		m.MaxInode = max(m.MaxInode, entry.Inode)
+		// Later rows win ties so that an append-only log reads naturally
+		if existing, ok := m.Entries[entry.Name]; !ok || !entry.Modified.Before(existing.Modified) {
+			m.Entries[entry.Name] = entry
+		}
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	affected := make(map[string]bool)
+	if old, ok := idx.Manifests[key]; ok {
+		for name := range old.Entries {
+			affected[logicalPath(key, name)] = true
+		}
+	}
+	idx.Manifests[key] = m
+	for name := range m.Entries {
+		affected[logicalPath(key, name)] = true
+	}
+
+	for p := range affected {
+		idx.setOwner(p, key, hasEntry(m, key, p))
+		idx.resolve(p)
+	}
+
+	idx.dirty = true
+	return nil
+}
+
+// Save durably persists the index if it changed since it was loaded or
+// last saved, so a crash leaves either the old or the new index.
+func (idx *PathIndex) Save() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if !idx.dirty {
+		return nil
+	}
+	if err := replaceJSON(filepath.Join(idx.storagePath, PathIndexFile), idx); err != nil {
+		return err
+	}
+
+	idx.dirty = false
+	return nil
+}
+
+// Lookup returns the current entry for a logical path. Deleted files and
+// directories are not returned.
+func (idx *PathIndex) Lookup(p string) (util.LookupEntry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	ie, ok := idx.live[p]
+	if !ok || ie.entry.Target == "" || ie.entry.IsDir() {
+		return util.LookupEntry{}, false
+	}
+	return ie.entry, true
+}
+
+// Current returns the newest entry for a logical path, which is a tombstone
+// for deleted files.
+func (idx *PathIndex) Current(p string) (util.LookupEntry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	ie, ok := idx.live[p]
+	return ie.entry, ok
+}
+
+// Manifest returns the absolute path of the lookup table holding the current
+// entry for logical path p, tombstones included.
+func (idx *PathIndex) Manifest(p string) (string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	ie, ok := idx.live[p]
+	if !ok || ie.manifest == "" {
+		return "", false
+	}
+	return filepath.Join(idx.storagePath, ie.manifest), true
+}
+
+// Boundary returns the absolute path of the lookup table of the deepest
+// indexed boundary containing logical path p, or the root lookup table if
+// there is none.
+func (idx *PathIndex) Boundary(p string) string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	for dir := path.Dir(p); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		key := filepath.Join(filepath.FromSlash(dir), "lookups.djfl")
+		if _, ok := idx.Manifests[key]; ok {
+			return filepath.Join(idx.storagePath, key)
+		}
+	}
+	return filepath.Join(idx.storagePath, "lookups.djfl")
+}
+
+// Boundaries returns the keys of the indexed lookup tables, their paths
+// relative to the storage directory.
+func (idx *PathIndex) Boundaries() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return slices.Collect(maps.Keys(idx.Manifests))
+}
+
+// Owners returns the absolute paths of the lookup tables holding entries for
+// logical path p, tombstones included.
+func (idx *PathIndex) Owners(p string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	manifests := make([]string, 0, len(idx.owners[p]))
+	for _, key := range idx.owners[p] {
+		manifests = append(manifests, filepath.Join(idx.storagePath, key))
+	}
+	return manifests
+}
+
+// Names returns the sorted names ever recorded directly below logical
+// directory dir, deleted ones included. The root is "".
+func (idx *PathIndex) Names(dir string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	names := make(map[string]bool)
+	for key, m := range idx.Manifests {
+		b := boundaryDir(key)
+		switch {
+		case len(m.Entries) == 0:
+		case b != dir && strings.HasPrefix(b, prefix):
+			// Everything in a boundary below dir is below its directory
+			name, _, _ := strings.Cut(strings.TrimPrefix(b, prefix), "/")
+			names[name] = true
+		case b == "" || b == dir || strings.HasPrefix(dir, b+"/"):
+			for name := range m.Entries {
+				if p := logicalPath(key, name); strings.HasPrefix(p, prefix) {
+					name, _, _ := strings.Cut(strings.TrimPrefix(p, prefix), "/")
+					names[name] = true
+				}
+			}
+		}
+	}
+	return slices.Sorted(maps.Keys(names))
+}
+
+// SetPending overlays a change that is recorded in the hot cache but not yet
+// in any lookup table. entry.Name is the logical path.
+func (idx *PathIndex) SetPending(entry util.LookupEntry) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.pending[entry.Name] = entry
+	idx.resolve(entry.Name)
+}
+
+// ClearPending drops overlaid changes once they were written to their lookup
+// tables.
+func (idx *PathIndex) ClearPending(paths ...string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, p := range paths {
+		delete(idx.pending, p)
+		idx.resolve(p)
+	}
+}
+
+// Walk returns the current entries of every live file and recorded directory
+// below logical directory dir.
+func (idx *PathIndex) Walk(dir string) []util.LookupEntry {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var entries []util.LookupEntry
+	prefix := dir + "/"
+	for p, ie := range idx.live {
+		if ie.entry.Target != "" && strings.HasPrefix(p, prefix) {
+			entries = append(entries, ie.entry)
+		}
+	}
+	return entries
+}
+
+// Usage returns the size and the number of live files. Older revisions,
+// tombstones and directories don't count.
+func (idx *PathIndex) Usage() (bytes, files uint64) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return uint64(idx.liveBytes), uint64(idx.liveFiles)
+}
+
+// MaxInode returns the highest inode recorded in any indexed lookup table.
+func (idx *PathIndex) MaxInode() uint64 {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var highest uint64
+	for _, m := range idx.Manifests {
+		highest = max(highest, m.MaxInode)
+	}
+	return highest
+}
+
+// IsDir reports whether the logical path dir is a directory, either recorded
+// explicitly or implied by live files below it.
+func (idx *PathIndex) IsDir(dir string) bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.isDir(dir)
+}
+
+// isDir is IsDir without locking. The caller must hold idx.mu.
+func (idx *PathIndex) isDir(dir string) bool {
+	return len(idx.children[dir]) > 0 || idx.live[dir].entry.IsDir()
+}
+
+// ReadDir lists the live children of a logical directory. The root is "".
+func (idx *PathIndex) ReadDir(dir string) []IndexDirent {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var dirents []IndexDirent
+	for name := range idx.children[dir] {
+		child := path.Join(dir, name)
+		if idx.isDir(child) {
+			dirents = append(dirents, IndexDirent{Name: name, IsDir: true})
+			continue
+		}
+		if ie, ok := idx.live[child]; ok && ie.entry.Target != "" {
+			dirents = append(dirents, IndexDirent{Name: name, Entry: ie.entry})
+		}
+	}
+
+	slices.SortFunc(dirents, func(a, b IndexDirent) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return dirents
+}
+
+// rebuild recomputes the derived maps from Manifests.
+func (idx *PathIndex) rebuild() {
+	idx.live = make(map[string]indexedEntry)
+	idx.liveBytes, idx.liveFiles = 0, 0
+	idx.owners = make(map[string][]string)
+	idx.children = make(map[string]map[string]int)
+	if idx.pending == nil {
+		idx.pending = make(map[string]util.LookupEntry)
+	}
+
+	for key, m := range idx.Manifests {
+		for name := range m.Entries {
+			p := logicalPath(key, name)
+			idx.owners[p] = append(idx.owners[p], key)
+		}
+	}
+	for p := range idx.owners {
+		idx.resolve(p)
+	}
+	for p := range idx.pending {
+		if _, ok := idx.owners[p]; !ok {
+			idx.resolve(p)
+		}
+	}
+}
+
+// dropManifest removes a manifest and everything it contributed.
+// The caller must hold idx.mu.
+func (idx *PathIndex) dropManifest(key string) {
+	m, ok := idx.Manifests[key]
+	if !ok {
+		return
+	}
+	delete(idx.Manifests, key)
+	for name := range m.Entries {
+		p := logicalPath(key, name)
+		idx.setOwner(p, key, false)
+		idx.resolve(p)
+	}
+	idx.dirty = true
+}
+
+// setOwner records whether manifest key holds an entry for logical path p.
+// The caller must hold idx.mu.
+func (idx *PathIndex) setOwner(p, key string, owns bool) {
+	owners := slices.DeleteFunc(idx.owners[p], func(k string) bool { return k == key })
+	if owns {
+		owners = append(owners, key)
+	}
+	if len(owners) == 0 {
+		delete(idx.owners, p)
+		return
+	}
+	idx.owners[p] = owners
+}
+
+// resolve picks the newest entry for logical path p across all manifests
+// that hold one and any pending change, and updates the directory tree
+// accordingly. Pending changes win ties.
+// The caller must hold idx.mu.
+func (idx *PathIndex) resolve(p string) {
+	var best indexedEntry
+	var found bool
+	for _, key := range idx.owners[p] {
+		entry, ok := idx.Manifests[key].Entries[relativeName(key, p)]
+		if !ok {
+			continue
+		}
+		if !found || entry.Modified.After(best.entry.Modified) {
+			entry.Name = p
+			best = indexedEntry{entry: entry, manifest: key}
+			found = true
+		}
+	}
+	if entry, ok := idx.pending[p]; ok && (!found || !entry.Modified.Before(best.entry.Modified)) {
+		best = indexedEntry{entry: entry}
+		found = true
+	}
+
+	old, existed := idx.live[p]
+	wasLive := existed && old.entry.Target != ""
+	isLive := found && best.entry.Target != ""
+
+	if wasLive && !old.entry.IsDir() {
+		idx.liveBytes -= old.entry.FileSize
+		idx.liveFiles--
+	}
+	if isLive && !best.entry.IsDir() {
+		idx.liveBytes += best.entry.FileSize
+		idx.liveFiles++
+	}
+	if found {
+		idx.live[p] = best
+	} else {
+		delete(idx.live, p)
+	}
+
+	switch {
+	case isLive && !wasLive:
+		idx.link(p, 1)
+	case wasLive && !isLive:
+		idx.link(p, -1)
+	}
+}
+
+// link adjusts the child counts of every ancestor of logical path p.
+// The caller must hold idx.mu.
+func (idx *PathIndex) link(p string, delta int) {
+	for p != "" && p != "." {
+		dir, name := path.Split(p)
+		dir = strings.TrimSuffix(dir, "/")
+
+		kids := idx.children[dir]
+		if kids == nil {
+			kids = make(map[string]int)
+			idx.children[dir] = kids
+		}
+		kids[name] += delta
+		if kids[name] <= 0 {
+			delete(kids, name)
+			if len(kids) == 0 {
+				delete(idx.children, dir)
+			}
+		}
+
+		p = dir
+	}
+}
+
+// hasEntry reports whether manifest m, stored under key, holds logical path p.
+func hasEntry(m *IndexedManifest, key, p string) bool {
+	_, ok := m.Entries[relativeName(key, p)]
+	return ok
+}
+
+// boundaryDir returns the logical directory a manifest key describes.
+func boundaryDir(key string) string {
+	dir := filepath.ToSlash(filepath.Dir(key))
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
+
+// logicalPath joins a boundary-relative entry name onto its boundary.
+func logicalPath(key, name string) string {
+	return path.Join(boundaryDir(key), filepath.ToSlash(name))
+}
+
+// relativeName is the inverse of logicalPath.
+func relativeName(key, p string) string {
+	dir := boundaryDir(key)
+	if dir == "" {
+		return p
+	}
+	return strings.TrimPrefix(p, dir+"/")
+}
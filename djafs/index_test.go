@@ -0,0 +1,203 @@
+package djafs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// writeLookupTable writes a lookups.djfl with the given entries into dir
+func writeLookupTable(t *testing.T, dir string, entries ...util.LookupEntry) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("Failed to create %s: %v", dir, err)
+	}
+	var lt util.LookupTable
+	for _, e := range entries {
+		lt.Add(e)
+	}
+	manifestPath := filepath.Join(dir, "lookups.djfl")
+	if err := util.WriteJSONFile(manifestPath, lt); err != nil {
+		t.Fatalf("Failed to write lookup table: %v", err)
+	}
+	return manifestPath
+}
+
+func TestPathIndex_SyncAndQuery(t *testing.T) {
+	storage := t.TempDir()
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	writeLookupTable(t, storage,
+		util.LookupEntry{Name: "top.json", Target: "1-00000-aaa", Modified: t1},
+	)
+	writeLookupTable(t, filepath.Join(storage, "sensors", "loc1"),
+		util.LookupEntry{Name: "device5/reading.json", Target: "1-00000-bbb", Modified: t1},
+		util.LookupEntry{Name: "device5/reading.json", Target: "1-00000-ccc", Modified: t2},
+		util.LookupEntry{Name: "summary.json", Target: "1-00000-ddd", Modified: t1},
+		util.LookupEntry{Name: "gone.json", Target: "1-00000-eee", Modified: t1},
+		util.LookupEntry{Name: "gone.json", Target: "", Modified: t2},
+	)
+
+	idx := LoadPathIndex(storage)
+	if err := idx.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	entry, ok := idx.Lookup("sensors/loc1/device5/reading.json")
+	if !ok {
+		t.Fatal("Expected to find sensors/loc1/device5/reading.json")
+	}
+	if entry.Target != "1-00000-ccc" {
+		t.Errorf("Expected newest target 1-00000-ccc, got %s", entry.Target)
+	}
+
+	if _, ok := idx.Lookup("sensors/loc1/gone.json"); ok {
+		t.Error("Deleted file should not be found")
+	}
+
+	if !idx.IsDir("sensors") || !idx.IsDir("sensors/loc1/device5") {
+		t.Error("Expected sensors and sensors/loc1/device5 to be directories")
+	}
+	if idx.IsDir("sensors/loc1/summary.json") {
+		t.Error("A file should not be reported as a directory")
+	}
+
+	root := idx.ReadDir("")
+	if len(root) != 2 || root[0].Name != "sensors" || !root[0].IsDir || root[1].Name != "top.json" || root[1].IsDir {
+		t.Errorf("Unexpected root listing: %+v", root)
+	}
+
+	loc1 := idx.ReadDir("sensors/loc1")
+	if len(loc1) != 2 || loc1[0].Name != "device5" || loc1[1].Name != "summary.json" {
+		t.Errorf("Unexpected sensors/loc1 listing: %+v", loc1)
+	}
+}
+
+func TestPathIndex_RefreshUpdatesTree(t *testing.T) {
+	storage := t.TempDir()
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	manifest := writeLookupTable(t, storage,
+		util.LookupEntry{Name: "a/b/c.json", Target: "1-00000-aaa", Modified: t1},
+	)
+
+	idx := LoadPathIndex(storage)
+	if err := idx.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if !idx.IsDir("a/b") {
+		t.Fatal("Expected a/b to be a directory")
+	}
+
+	// Delete the only file and add a new one elsewhere
+	writeLookupTable(t, storage,
+		util.LookupEntry{Name: "a/b/c.json", Target: "1-00000-aaa", Modified: t1},
+		util.LookupEntry{Name: "a/b/c.json", Target: "", Modified: t1.Add(time.Minute)},
+		util.LookupEntry{Name: "x.json", Target: "1-00000-bbb", Modified: t1.Add(time.Minute)},
+	)
+	if err := idx.Refresh(manifest); err != nil {
+		t.Fatalf("Refresh failed: %v", err)
+	}
+
+	if idx.IsDir("a") || idx.IsDir("a/b") {
+		t.Error("Directories without live files should disappear")
+	}
+	if _, ok := idx.Lookup("x.json"); !ok {
+		t.Error("Expected x.json after refresh")
+	}
+	if got := idx.ReadDir(""); len(got) != 1 || got[0].Name != "x.json" {
+		t.Errorf("Unexpected root listing: %+v", got)
+	}
+}
+
+func TestPathIndex_Persistence(t *testing.T) {
+	storage := t.TempDir()
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	writeLookupTable(t, filepath.Join(storage, "one"),
+		util.LookupEntry{Name: "f.json", Target: "1-00000-aaa", Modified: t1},
+	)
+	removed := writeLookupTable(t, filepath.Join(storage, "two"),
+		util.LookupEntry{Name: "g.json", Target: "1-00000-bbb", Modified: t1},
+	)
+
+	idx := LoadPathIndex(storage)
+	if err := idx.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if err := idx.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storage, PathIndexFile)); err != nil {
+		t.Fatalf("Expected persisted index: %v", err)
+	}
+
+	// A reloaded index answers queries before any sync
+	reloaded := LoadPathIndex(storage)
+	if _, ok := reloaded.Lookup("two/g.json"); !ok {
+		t.Error("Reloaded index should contain two/g.json")
+	}
+
+	// Changes made while unmounted are picked up by Sync
+	os.Remove(removed)
+	writeLookupTable(t, filepath.Join(storage, "three"),
+		util.LookupEntry{Name: "h.json", Target: "1-00000-ccc", Modified: t1},
+	)
+	if err := reloaded.Sync(); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if _, ok := reloaded.Lookup("two/g.json"); ok {
+		t.Error("Entries of a removed manifest should be dropped")
+	}
+	if _, ok := reloaded.Lookup("three/h.json"); !ok {
+		t.Error("Entries of a new manifest should be indexed")
+	}
+	if _, ok := reloaded.Lookup("one/f.json"); !ok {
+		t.Error("Unchanged manifests should stay indexed")
+	}
+}
+
+func TestFS_Statfs(t *testing.T) {
+	storage := t.TempDir()
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeLookupTable(t, filepath.Join(storage, "one"),
+		util.LookupEntry{Name: "f.json", Target: "1-00000-aaa", FileSize: 1 << 20, Modified: t1},
+		util.LookupEntry{Name: "g.json", Target: "1-00000-bbb", FileSize: 1 << 20, Modified: t1},
+	)
+	writeLookupTable(t, filepath.Join(storage, "two"),
+		util.LookupEntry{Name: "h.json", Target: "1-00000-ccc", FileSize: 3, Modified: t1},
+		// Older revisions, tombstones and directories take no space
+		util.LookupEntry{Name: "old.json", Target: "1-00000-ddd", FileSize: 1 << 20, Modified: t1},
+		util.LookupEntry{Name: "old.json", Modified: t1.Add(time.Hour)},
+		util.LookupEntry{Name: "dir", Target: util.DirTarget, FileSize: 1 << 20, Modified: t1},
+		util.LookupEntry{Name: "h.json", Target: "1-00000-ccc", FileSize: 3, Modified: t1.Add(time.Hour), Mode: 0o100600},
+	)
+
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	if bytes, files := fsys.Index.Usage(); bytes != 2<<20+3 || files != 3 {
+		t.Errorf("Expected 2 MiB + 3 bytes in 3 files, got %d bytes in %d files", bytes, files)
+	}
+
+	var resp fuse.StatfsResponse
+	if err := fsys.Statfs(context.Background(), &fuse.StatfsRequest{}, &resp); err != nil {
+		t.Fatalf("Statfs failed: %v", err)
+	}
+	used := (resp.Blocks - resp.Bfree) * uint64(resp.Bsize)
+	if used < 2<<20+3 || used >= 2<<20+3+uint64(resp.Bsize) {
+		t.Errorf("Expected the logical size as used space, got %d bytes", used)
+	}
+	if resp.Files-resp.Ffree != 3 {
+		t.Errorf("Expected 3 used inodes, got %d", resp.Files-resp.Ffree)
+	}
+	if resp.Bavail == 0 || resp.Namelen == 0 {
+		t.Errorf("Expected free space and a name limit, got %+v", resp)
+	}
+}
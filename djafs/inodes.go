@@ -0,0 +1,224 @@
+package djafs
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// InodeMapFile is the name of the persisted inodes of a boundary, stored
+// next to its lookups.djfl.
+const InodeMapFile = "inodes.djfi"
+
+// Inodes of the fixed top-level directories
+const (
+	rootInode      = 1
+	liveInode      = 2
+	snapshotsInode = 3
+)
+
+// InodeMap assigns every path below /live an inode once and keeps it across
+// remounts. Keys are mount paths such as "/live/a/b.json". Paths of the
+// virtual trees, such as /snapshots and /versions, are unbounded, so their
+// inodes are derived from the path instead of being stored.
+//
+// The inodes of a path are stored with the boundary holding it, so a change
+// only rewrites the shard of its boundary. New inodes come from
+// util.GetNewInode, which LoadInodeMap seeds with the highest inode any
+// shard ever handed out so that they never collide with stored ones.
+type InodeMap struct {
+	storagePath string
+	index       *PathIndex
+	inodes      map[string]uint64      // mount path -> inode
+	shards      map[string]*inodeShard // shard path relative to storage -> shard
+	shardOf     map[string]string      // mount path -> shard holding it
+	dirty       map[string]bool        // shards changed since the last Save
+	mu          sync.Mutex
+}
+
+// inodeShard is the persisted inodes of a single boundary
+type inodeShard struct {
+	Inodes map[string]uint64 `json:"inodes"` // boundary-relative name -> inode
+	Max    uint64            `json:"max"`    // highest inode allocated in the shard
+}
+
+// LoadInodeMap loads the inodes stored with every boundary of index.
+// Missing or unreadable shards are empty.
+func LoadInodeMap(storagePath string, index *PathIndex) *InodeMap {
+	im := &InodeMap{
+		storagePath: storagePath,
+		index:       index,
+		inodes:      make(map[string]uint64),
+		shards:      make(map[string]*inodeShard),
+		shardOf:     make(map[string]string),
+		dirty:       make(map[string]bool),
+	}
+
+	highest := uint64(snapshotsInode)
+	for _, manifest := range append(index.Boundaries(), "lookups.djfl") {
+		key := shardKey(manifest)
+		if _, ok := im.shards[key]; ok {
+			continue
+		}
+		shard := im.shard(key)
+		f, err := os.Open(filepath.Join(storagePath, key))
+		if err == nil {
+			if err := json.NewDecoder(f).Decode(shard); err != nil {
+				fmt.Printf("Ignoring unreadable inode map %s: %v\n", key, err)
+				*shard = inodeShard{}
+			}
+			f.Close()
+		}
+		if shard.Inodes == nil {
+			shard.Inodes = make(map[string]uint64)
+		}
+		for name, inode := range shard.Inodes {
+			p := "/live/" + logicalPath(manifest, name)
+			im.inodes[p] = inode
+			im.shardOf[p] = key
+		}
+		highest = max(highest, shard.Max)
+	}
+
+	im.inodes["/"] = rootInode
+	im.inodes["/live"] = liveInode
+	im.inodes["/snapshots"] = snapshotsInode
+	util.SetInode(highest)
+
+	return im
+}
+
+// shardKey returns the shard stored next to the lookup table manifest, both
+// relative to the storage directory
+func shardKey(manifest string) string {
+	return filepath.Join(filepath.Dir(manifest), InodeMapFile)
+}
+
+// shard returns the shard at key, creating an empty one. The caller must
+// hold im.mu.
+func (im *InodeMap) shard(key string) *inodeShard {
+	shard, ok := im.shards[key]
+	if !ok {
+		shard = &inodeShard{Inodes: make(map[string]uint64)}
+		im.shards[key] = shard
+	}
+	return shard
+}
+
+// Get returns the inode of a mount path, allocating one on first use.
+// Paths outside /live get an inode derived from the path.
+func (im *InodeMap) Get(p string) uint64 {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if inode, ok := im.inodes[p]; ok {
+		return inode
+	}
+	if !persistent(p) {
+		return virtualInode(p)
+	}
+	inode := util.GetNewInode()
+	im.store(p, inode)
+	return inode
+}
+
+// store records the inode of a path below /live in the shard of its
+// boundary. The caller must hold im.mu.
+func (im *InodeMap) store(p string, inode uint64) {
+	logical := strings.TrimPrefix(p, "/live/")
+	manifest, err := filepath.Rel(im.storagePath, im.index.Boundary(logical))
+	if err != nil {
+		manifest = "lookups.djfl"
+	}
+	key := shardKey(manifest)
+	shard := im.shard(key)
+	shard.Inodes[relativeName(manifest, logical)] = inode
+	shard.Max = max(shard.Max, inode)
+
+	im.inodes[p] = inode
+	im.shardOf[p] = key
+	im.dirty[key] = true
+}
+
+// remove drops the inode of a path from its shard. The caller must hold
+// im.mu.
+func (im *InodeMap) remove(p string) {
+	key, ok := im.shardOf[p]
+	if !ok {
+		return
+	}
+	manifest := filepath.Join(filepath.Dir(key), "lookups.djfl")
+	delete(im.shards[key].Inodes, relativeName(manifest, strings.TrimPrefix(p, "/live/")))
+	delete(im.inodes, p)
+	delete(im.shardOf, p)
+	im.dirty[key] = true
+}
+
+// persistent reports whether the inode of mount path p is stored
+func persistent(p string) bool {
+	return strings.HasPrefix(p, "/live/")
+}
+
+// virtualInode derives the inode of a path outside /live from its hash. The
+// top bit is set, so it never collides with an allocated inode.
+func virtualInode(p string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(p))
+	return h.Sum64() | 1<<63
+}
+
+// Move transfers the inodes of oldPath and everything below it to newPath,
+// so renamed files and directories keep their identity.
+func (im *InodeMap) Move(oldPath, newPath string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	moved := make(map[string]uint64)
+	for p, inode := range im.inodes {
+		switch {
+		case p == oldPath:
+			moved[newPath] = inode
+		case strings.HasPrefix(p, oldPath+"/"):
+			moved[newPath+strings.TrimPrefix(p, oldPath)] = inode
+		default:
+			continue
+		}
+		im.remove(p)
+	}
+	for p, inode := range moved {
+		im.store(p, inode)
+	}
+}
+
+// Forget drops the inode of a deleted path, so a file created in its place
+// gets a new one.
+func (im *InodeMap) Forget(p string) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.remove(p)
+}
+
+// Save durably writes the shards that changed since they were loaded or
+// last saved.
+func (im *InodeMap) Save() error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	for key := range im.dirty {
+		shardPath := filepath.Join(im.storagePath, key)
+		if err := os.MkdirAll(filepath.Dir(shardPath), 0755); err != nil {
+			return err
+		}
+		if err := replaceJSON(shardPath, im.shards[key]); err != nil {
+			return err
+		}
+		delete(im.dirty, key)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package djafs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+func TestInodeMap_Persistence(t *testing.T) {
+	storage := t.TempDir()
+
+	im := LoadInodeMap(storage, LoadPathIndex(storage))
+	if im.Get("/") != rootInode || im.Get("/live") != liveInode || im.Get("/snapshots") != snapshotsInode {
+		t.Error("Fixed directories should keep their reserved inodes")
+	}
+
+	a := im.Get("/live/a")
+	if im.Get("/live/a") != a {
+		t.Error("Get should return the same inode for the same path")
+	}
+	b := im.Get("/live/a/b.json")
+	if a == b {
+		t.Error("Different paths should get different inodes")
+	}
+	if err := im.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	reloaded := LoadInodeMap(storage, LoadPathIndex(storage))
+	if reloaded.Get("/live/a") != a || reloaded.Get("/live/a/b.json") != b {
+		t.Error("Inodes should survive a reload")
+	}
+	if fresh := util.GetNewInode(); fresh <= b {
+		t.Errorf("New inodes must be above the stored maximum %d, got %d", b, fresh)
+	}
+}
+
+func TestInodeMap_VirtualPathsNotStored(t *testing.T) {
+	storage := t.TempDir()
+	im := LoadInodeMap(storage, LoadPathIndex(storage))
+
+	snapshot := im.Get("/snapshots/@2024-03-05T00:00:00Z/a.json")
+	version := im.Get("/versions/a.json")
+	if snapshot == version || im.Get("/versions/a.json") != version {
+		t.Error("Virtual paths should get stable, distinct inodes")
+	}
+	if snapshot == im.Get("/live/a.json") {
+		t.Error("Virtual inodes should not collide with allocated ones")
+	}
+	if err := im.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	reloaded := LoadInodeMap(storage, LoadPathIndex(storage))
+	if len(reloaded.inodes) != 4 {
+		t.Errorf("Expected only the fixed directories and /live/a.json stored, got %v", reloaded.inodes)
+	}
+	if reloaded.Get("/versions/a.json") != version {
+		t.Error("Virtual inodes should be the same across remounts")
+	}
+}
+
+func TestInodeMap_ShardedByBoundary(t *testing.T) {
+	storage := t.TempDir()
+	writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "a.json", Target: "1-00000-aaa", Modified: time.Now()},
+	)
+	index := LoadPathIndex(storage)
+	index.Sync()
+
+	im := LoadInodeMap(storage, index)
+	top := im.Get("/live/top.json")
+	sensor := im.Get("/live/sensors/a.json")
+	if err := im.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	rootShard := filepath.Join(storage, InodeMapFile)
+	sensorShard := filepath.Join(storage, "sensors", InodeMapFile)
+	for p, name := range map[string]string{rootShard: "top.json", sensorShard: "a.json"} {
+		var shard inodeShard
+		data, err := os.ReadFile(p)
+		if err == nil {
+			err = json.Unmarshal(data, &shard)
+		}
+		if err != nil {
+			t.Fatalf("Reading %s failed: %v", p, err)
+		}
+		if len(shard.Inodes) != 1 || shard.Inodes[name] == 0 {
+			t.Errorf("Expected only %s in %s, got %v", name, p, shard.Inodes)
+		}
+	}
+
+	// Only the shard of the changed boundary is written
+	os.Remove(rootShard)
+	im.Get("/live/sensors/b.json")
+	if err := im.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := os.Stat(rootShard); !os.IsNotExist(err) {
+		t.Error("Save rewrote the shard of an unchanged boundary")
+	}
+
+	// A move between boundaries moves the inode between shards
+	im.Move("/live/sensors/a.json", "/live/moved.json")
+	if err := im.Save(); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	reloaded := LoadInodeMap(storage, index)
+	if reloaded.Get("/live/moved.json") != sensor || reloaded.Get("/live/top.json") != top {
+		t.Error("Inodes should survive a move between boundaries")
+	}
+	if _, ok := reloaded.inodes["/live/sensors/a.json"]; ok {
+		t.Error("A moved path should be dropped from the shard it left")
+	}
+}
+
+func TestInodeMap_MoveAndForget(t *testing.T) {
+	storage := t.TempDir()
+	im := LoadInodeMap(storage, LoadPathIndex(storage))
+	dir := im.Get("/live/old")
+	file := im.Get("/live/old/x.json")
+	sibling := im.Get("/live/older")
+
+	im.Move("/live/old", "/live/new")
+	if im.Get("/live/new") != dir || im.Get("/live/new/x.json") != file {
+		t.Error("Move should carry inodes of the directory and its contents")
+	}
+	if im.Get("/live/older") != sibling {
+		t.Error("Move should not touch paths that only share a prefix")
+	}
+
+	im.Forget("/live/new/x.json")
+	if im.Get("/live/new/x.json") == file {
+		t.Error("A forgotten path should get a new inode")
+	}
+}
+
+func TestFS_StableInodes(t *testing.T) {
+	storage := t.TempDir()
+	writeLookupTable(t, filepath.Join(storage, "a"),
+		util.LookupEntry{Name: "b/c.json", Target: "1-00000-aaa", Inode: 500000, Modified: time.Now()},
+	)
+
+	attrs := func(fsys *FS) (dirInode, fileInode uint64) {
+		t.Helper()
+		ctx := context.Background()
+		live := &Dir{fs: fsys, path: "/live"}
+		node, err := live.Lookup(ctx, "a")
+		if err != nil {
+			t.Fatalf("Lookup a failed: %v", err)
+		}
+		var attr fuse.Attr
+		node.Attr(ctx, &attr)
+		dirInode = attr.Inode
+
+		node, err = (&Dir{fs: fsys, path: "/live/a/b"}).Lookup(ctx, "c.json")
+		if err != nil {
+			t.Fatalf("Lookup c.json failed: %v", err)
+		}
+		node.Attr(ctx, &attr)
+		return dirInode, attr.Inode
+	}
+
+	fsys := NewFS(storage)
+	dirInode, fileInode := attrs(fsys)
+	if again, _ := attrs(fsys); again != dirInode {
+		t.Errorf("Directory inode changed between calls: %d then %d", dirInode, again)
+	}
+	if dirInode <= 500000 || fileInode <= 500000 {
+		t.Errorf("Allocated inodes must not collide with stored entries, got %d and %d", dirInode, fileInode)
+	}
+	var root fuse.Attr
+	(&Dir{fs: fsys, path: "/"}).Attr(context.Background(), &root)
+	if root.Inode != rootInode || dirInode == rootInode {
+		t.Error("Directories should have distinct inodes")
+	}
+
+	dirents, _ := (&Dir{fs: fsys, path: "/live"}).ReadDirAll(context.Background())
+	if len(dirents) != 1 || dirents[0].Inode != dirInode {
+		t.Errorf("ReadDirAll inode should match Attr, got %+v", dirents)
+	}
+	fsys.Stop()
+
+	remounted := NewFS(storage)
+	defer remounted.Stop()
+	if d, f := attrs(remounted); d != dirInode || f != fileInode {
+		t.Errorf("Inodes changed across remount: %d/%d then %d/%d", dirInode, fileInode, d, f)
+	}
+}
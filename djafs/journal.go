@@ -0,0 +1,496 @@
+package djafs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// PendingFile is the hot cache journal of changes that don't carry new
+// content, such as deletions, renames and new directories. It is a lookup table whose entry names are
+// logical paths; the garbage collector moves its entries into the lookup
+// table of each path's boundary.
+const PendingFile = "pending.djfl"
+
+// pendingPath returns the location of the hot cache journal
+func (hc *HotCache) pendingPath() string {
+	return filepath.Join(filepath.Dir(hc.IncomingDir), PendingFile)
+}
+
+// loadPending reads the journal. A missing journal is empty.
+func (hc *HotCache) loadPending() (util.LookupTable, error) {
+	lt, err := util.ReadLookupTable(hc.pendingPath())
+	if os.IsNotExist(err) {
+		return lt, nil
+	}
+	return lt, err
+}
+
+// savePending replaces the journal atomically
+func (hc *HotCache) savePending(lt util.LookupTable) error {
+	return replaceTable(hc.pendingPath(), lt)
+}
+
+// replaceTable atomically replaces the lookup table at p, removing it when
+// lt is empty
+func replaceTable(p string, lt util.LookupTable) error {
+	if lt.Len() == 0 {
+		err := os.Remove(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return replaceJSON(p, lt)
+}
+
+// replaceJSON replaces the file at p with v encoded as JSON. The new
+// content is synced before it is renamed into place and the directory
+// after, so a crash leaves either the old or the new file.
+func replaceJSON(p string, v any) error {
+	tmpPath := p + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(f).Encode(v)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, p)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncDir(filepath.Dir(p))
+}
+
+// restorePending overlays journal entries left by a previous mount
+func (hc *HotCache) restorePending() {
+	lt, err := hc.loadPending()
+	if err != nil {
+		fmt.Printf("Error reading hot cache journal: %v\n", err)
+		return
+	}
+	for entry := range lt.Iterate {
+		hc.fs.Index.SetPending(entry)
+	}
+}
+
+// recordEntries appends entries for logical paths to the journal in a
+// single write and makes them visible immediately. The caller must hold
+// hc.mu.
+func (hc *HotCache) recordEntries(entries ...util.LookupEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	lt, err := hc.loadPending()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		lt.Add(entry)
+	}
+	if err := hc.savePending(lt); err != nil {
+		return fmt.Errorf("failed to write hot cache journal: %w", err)
+	}
+	for _, entry := range entries {
+		hc.fs.Index.SetPending(entry)
+	}
+	return nil
+}
+
+// RemoveFile deletes the file at logical path p. Files that only exist in the
+// hot cache are dropped from it; archived files get a tombstone so snapshots
+// from before the deletion still show them.
+func (hc *HotCache) RemoveFile(p string) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	removed := false
+	if incomingPath := filepath.Join(hc.IncomingDir, p); isFile(incomingPath) {
+		removed = os.Remove(incomingPath) == nil
+	}
+	_, stagingErr := os.Stat(filepath.Join(hc.StagingDir, p))
+
+	if err := hc.forgetOwner(p); err != nil {
+		return err
+	}
+
+	entry, archived := hc.fs.Index.Lookup(p)
+	if !archived && stagingErr != nil {
+		if removed {
+			return nil
+		}
+		return syscall.ENOENT
+	}
+
+	return hc.recordEntries(util.LookupEntry{
+		Inode:    entry.Inode,
+		Modified: time.Now(),
+		Name:     p,
+	})
+}
+
+// MakeDir records a directory at logical path p with the mode and owner of
+// owner, so it exists even while no file is stored below it.
+func (hc *HotCache) MakeDir(p string, owner util.LookupEntry) error {
+	if reserved(p) {
+		return syscall.EPERM
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	_, archived := hc.fs.Index.Lookup(p)
+	hf, hot := hc.stat(p)
+	if archived || hc.fs.Index.IsDir(p) || hc.isHotDir(p) || (hot && hc.fs.hotWins(p, hf)) {
+		return syscall.EEXIST
+	}
+
+	entry := util.LookupEntry{
+		Modified: time.Now(),
+		Name:     p,
+		Target:   util.DirTarget,
+	}
+	copyOwner(&entry, owner)
+	return hc.recordEntries(entry)
+}
+
+// RemoveDir deletes the empty directory at logical path p. Directories with
+// files below them are refused by the caller.
+func (hc *HotCache) RemoveDir(p string) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	err := os.Remove(filepath.Join(hc.IncomingDir, p))
+	if errors.Is(err, syscall.ENOTEMPTY) {
+		return syscall.ENOTEMPTY
+	}
+
+	if current, ok := hc.fs.Index.Current(p); ok && current.IsDir() {
+		return hc.recordEntries(util.LookupEntry{Modified: time.Now(), Name: p})
+	}
+
+	// Report ENOENT as such rather than as an I/O error
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return err
+}
+
+// Rename moves the file or directory at logical path oldPath to newPath.
+// Archived content is never copied: the new name gets an entry pointing at
+// the same Target and the old name a tombstone, so both names keep their
+// history in snapshots. Files still in the hot cache are moved within it.
+func (hc *HotCache) Rename(oldPath, newPath string) error {
+	hc.mu.Lock()
+	if exists(filepath.Join(hc.StagingDir, oldPath)) {
+		// A collection is archiving what is renamed. Once it is done the
+		// content is archived or back in incoming.
+		hc.mu.Unlock()
+		hc.gcMu.Lock()
+		defer hc.gcMu.Unlock()
+		hc.mu.Lock()
+	}
+	defer hc.mu.Unlock()
+
+	if oldPath == newPath {
+		return nil
+	}
+	if strings.HasPrefix(newPath, oldPath+"/") {
+		return syscall.EINVAL
+	}
+
+	_, dstArchived := hc.fs.Index.Lookup(newPath)
+	dstIsFile := dstArchived || isFile(filepath.Join(hc.IncomingDir, newPath))
+
+	srcIsDir := hc.fs.Index.IsDir(oldPath) || hc.isHotDir(oldPath)
+	if !srcIsDir {
+		if hc.fs.Index.IsDir(newPath) || hc.isHotDir(newPath) {
+			return syscall.EISDIR
+		}
+		if err := hc.renameFile(oldPath, newPath, time.Now()); err != nil {
+			return err
+		}
+		return hc.moveOwners(oldPath, newPath)
+	}
+
+	switch {
+	case dstIsFile:
+		return syscall.ENOTDIR
+	case len(hc.fs.Index.ReadDir(newPath)) > 0 ||
+		hasChildren(filepath.Join(hc.IncomingDir, newPath)) ||
+		hasChildren(filepath.Join(hc.StagingDir, newPath)):
+		return syscall.ENOTEMPTY
+	}
+	if err := hc.renameDir(oldPath, newPath, time.Now()); err != nil {
+		return err
+	}
+	return hc.moveOwners(oldPath, newPath)
+}
+
+// renameFile moves a single file. The caller must hold hc.mu, and hc.gcMu
+// if the file is in staging.
+func (hc *HotCache) renameFile(oldPath, newPath string, now time.Time) error {
+	incomingSrc := filepath.Join(hc.IncomingDir, oldPath)
+	incomingDst := filepath.Join(hc.IncomingDir, newPath)
+
+	// A file a failed collection left in staging is renamed from incoming
+	if stagingSrc := filepath.Join(hc.StagingDir, oldPath); isFile(stagingSrc) {
+		hc.rollBack(stagingSrc, oldPath, nil)
+	}
+
+	// The newest version of a file is in incoming, then staging, then archives
+	if isFile(incomingSrc) {
+		if err := os.MkdirAll(filepath.Dir(incomingDst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(incomingSrc, incomingDst); err != nil {
+			return err
+		}
+		// The rename is the newest change at newPath, which the entry
+		// written by the garbage collector must reflect
+		os.Chtimes(incomingDst, now, now)
+
+		if hc.hasOlderVersion(oldPath) {
+			return hc.recordEntries(util.LookupEntry{Modified: now, Name: oldPath})
+		}
+		return nil
+	}
+
+	entry, err := hc.currentEntry(oldPath)
+	if err != nil {
+		return err
+	}
+
+	// A stale hot cache copy must not shadow the renamed file later
+	os.Remove(incomingDst)
+
+	tombstone := util.LookupEntry{Inode: entry.Inode, Modified: now, Name: oldPath}
+	entry.Name = newPath
+	entry.Modified = now
+	return hc.recordEntries(entry, tombstone)
+}
+
+// renameDir moves every file below a directory. The caller must hold hc.mu,
+// and hc.gcMu if files below it are in staging.
+func (hc *HotCache) renameDir(oldPath, newPath string, now time.Time) error {
+	moved := make(map[string]bool) // paths below oldPath already handled
+	var entries []util.LookupEntry // recorded together once all are known
+
+	// Files a failed collection left in staging are renamed from incoming
+	stagingSrc := filepath.Join(hc.StagingDir, oldPath)
+	var leftovers []string
+	filepath.Walk(stagingSrc, func(p string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			leftovers = append(leftovers, p)
+		}
+		return nil
+	})
+	for _, p := range leftovers {
+		rel, _ := filepath.Rel(hc.StagingDir, p)
+		hc.rollBack(p, filepath.ToSlash(rel), nil)
+	}
+
+	// A recorded directory moves like a file
+	if current, ok := hc.fs.Index.Current(oldPath); ok && current.IsDir() {
+		current.Name = newPath
+		current.Modified = now
+		entries = append(entries, current, util.LookupEntry{Modified: now, Name: oldPath})
+	}
+
+	// Move what is still in incoming as a whole
+	incomingSrc := filepath.Join(hc.IncomingDir, oldPath)
+	if isDir(incomingSrc) {
+		incomingDst := filepath.Join(hc.IncomingDir, newPath)
+		if err := os.MkdirAll(filepath.Dir(incomingDst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(incomingSrc, incomingDst); err != nil {
+			var errno syscall.Errno
+			if errors.As(err, &errno) {
+				return errno
+			}
+			return err
+		}
+		filepath.Walk(incomingDst, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			rel, _ := filepath.Rel(incomingDst, p)
+			moved[filepath.ToSlash(rel)] = true
+			os.Chtimes(p, now, now)
+			return nil
+		})
+	}
+
+	// Collect the remaining files from staging and the archives
+	rels := make(map[string]bool)
+	filepath.Walk(filepath.Join(hc.StagingDir, oldPath), func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(filepath.Join(hc.StagingDir, oldPath), p)
+		rels[filepath.ToSlash(rel)] = true
+		return nil
+	})
+	for _, entry := range hc.fs.Index.Walk(oldPath) {
+		rels[strings.TrimPrefix(entry.Name, oldPath+"/")] = true
+	}
+
+	for rel := range rels {
+		src := path.Join(oldPath, rel)
+		if !moved[rel] {
+			entry, err := hc.currentEntry(src)
+			if err != nil {
+				return err
+			}
+			entry.Name = path.Join(newPath, rel)
+			entry.Modified = now
+			entries = append(entries, entry)
+		}
+		entries = append(entries, util.LookupEntry{Modified: now, Name: src})
+	}
+	return hc.recordEntries(entries...)
+}
+
+// currentEntry returns the entry of an archived file or recorded directory.
+// Renames wait for collections archiving their files, so entries only ever
+// point at archived content. The caller must hold hc.mu.
+func (hc *HotCache) currentEntry(p string) (util.LookupEntry, error) {
+	if entry, ok := hc.fs.Index.Current(p); ok && entry.Target != "" {
+		return entry, nil
+	}
+	return util.LookupEntry{}, syscall.ENOENT
+}
+
+// hasOlderVersion reports whether p has a version outside incoming that a
+// move out of incoming must hide. The caller must hold hc.mu.
+func (hc *HotCache) hasOlderVersion(p string) bool {
+	if _, ok := hc.fs.Index.Lookup(p); ok {
+		return true
+	}
+	return isFile(filepath.Join(hc.StagingDir, p))
+}
+
+// isHotDir is IsDir without locking. The caller must hold hc.mu.
+func (hc *HotCache) isHotDir(p string) bool {
+	return !reserved(p) && isDir(filepath.Join(hc.IncomingDir, p)) || isDir(filepath.Join(hc.StagingDir, p))
+}
+
+// hasChildren reports whether the directory p exists and is not empty
+func hasChildren(p string) bool {
+	dirents, err := os.ReadDir(p)
+	return err == nil && len(dirents) > 0
+}
+
+// isFile reports whether p is an existing regular file
+func isFile(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// exists reports whether anything exists at p
+func exists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
+
+// isDir reports whether p is an existing directory
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
+
+// applyPending moves journaled entries into the lookup tables of their
+// boundaries. The tables are rewritten without hc.mu, which is only taken
+// to read and shorten the journal, so writers don't wait for it. The
+// caller must hold hc.gcMu.
+func (hc *HotCache) applyPending() error {
+	hc.mu.RLock()
+	lt, err := hc.loadPending()
+	hc.mu.RUnlock()
+	if err != nil || lt.Len() == 0 {
+		return err
+	}
+
+	var entries []util.LookupEntry
+	for entry := range lt.Iterate {
+		entries = append(entries, entry)
+	}
+	if err := hc.updateLookupTable(entries...); err != nil {
+		return err
+	}
+
+	// Entries are only appended meanwhile, those after the applied ones
+	// stay for the next collection
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	current, err := hc.loadPending()
+	if err != nil {
+		return err
+	}
+	var rest util.LookupTable
+	newer := make(map[string]bool)
+	for i := lt.Len(); i < current.Len(); i++ {
+		entry := current.Get(i)
+		rest.Add(entry)
+		newer[entry.Name] = true
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !newer[entry.Name] {
+			paths = append(paths, entry.Name)
+		}
+	}
+	if err := hc.savePending(rest); err != nil {
+		return err
+	}
+	hc.fs.Index.ClearPending(paths...)
+	return nil
+}
+
+// appendEntries adds entries to the lookup table at manifestPath and
+// refreshes everything derived from it. The table and its metadata are
+// replaced durably, so a crash can't lose the history they hold.
+func (hc *HotCache) appendEntries(manifestPath string, entries ...util.LookupEntry) error {
+	lookupTable, err := util.ReadLookupTable(manifestPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		lookupTable.Add(entry)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+		return err
+	}
+	if err := replaceTable(manifestPath, lookupTable); err != nil {
+		return err
+	}
+	metadata, err := lookupTable.GenerateMetadata("")
+	if err != nil {
+		return err
+	}
+	if err := replaceJSON(filepath.Join(filepath.Dir(manifestPath), "metadata.djfm"), metadata); err != nil {
+		return err
+	}
+
+	// Drop the stale cached copy and pick up the new entries in the index
+	hc.fs.invalidateLookupTable(manifestPath)
+	return hc.fs.Index.Refresh(manifestPath)
+}
@@ -0,0 +1,349 @@
+package djafs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+func TestRemove_RecordsTombstone(t *testing.T) {
+	storage := t.TempDir()
+	created := time.Now().Add(-time.Hour)
+	manifestPath := writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "loc1/reading.json", Target: "1-00000-aaa", Modified: created},
+		util.LookupEntry{Name: "loc1/other.json", Target: "1-00000-bbb", Modified: created},
+	)
+
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	dir := &Dir{fs: fsys, path: "/live/sensors/loc1"}
+	ctx := context.Background()
+	if err := dir.Remove(ctx, &fuse.RemoveRequest{Name: "reading.json"}); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := dir.Lookup(ctx, "reading.json"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Expected ENOENT after Remove, got %v", err)
+	}
+	if err := dir.Remove(ctx, &fuse.RemoveRequest{Name: "reading.json"}); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Expected ENOENT removing a deleted file, got %v", err)
+	}
+
+	// The deletion survives a remount before the journal is applied
+	remounted := NewFS(storage)
+	if _, ok := remounted.Index.Lookup("sensors/loc1/reading.json"); ok {
+		t.Error("Journaled deletion should survive a remount")
+	}
+	remounted.Stop()
+
+	fsys.HotCache.processFiles()
+	if _, err := os.Stat(fsys.HotCache.pendingPath()); !os.IsNotExist(err) {
+		t.Error("Journal should be empty once applied")
+	}
+
+	// The tombstone lands in the boundary that holds the file
+	lt, err := util.ReadLookupTable(manifestPath)
+	if err != nil {
+		t.Fatalf("ReadLookupTable failed: %v", err)
+	}
+	tombstone := lt.Get(lt.Len() - 1)
+	if tombstone.Name != "loc1/reading.json" || tombstone.Target != "" {
+		t.Errorf("Expected tombstone for loc1/reading.json, got %+v", tombstone)
+	}
+	if _, ok := fsys.Index.Lookup("sensors/loc1/reading.json"); ok {
+		t.Error("Deleted file should stay hidden after the journal is applied")
+	}
+	if _, ok := fsys.Index.Lookup("sensors/loc1/other.json"); !ok {
+		t.Error("Sibling file should be unaffected")
+	}
+
+	// Snapshots from before the deletion still show the file
+	before := created.Add(time.Minute)
+	if _, err := fsys.findFileEntryAtTime("/sensors/loc1/reading.json", &before); err != nil {
+		t.Errorf("Snapshot before deletion should show the file: %v", err)
+	}
+	if _, err := fsys.findFileEntryAtTime("/sensors/loc1/reading.json", nil); err == nil {
+		t.Error("Latest snapshot should not show the deleted file")
+	}
+}
+
+func TestRemove_Directories(t *testing.T) {
+	storage := t.TempDir()
+	writeLookupTable(t, storage,
+		util.LookupEntry{Name: "full/reading.json", Target: "1-00000-aaa", Modified: time.Now()},
+	)
+
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	live := &Dir{fs: fsys, path: "/live"}
+	ctx := context.Background()
+	if err := live.Remove(ctx, &fuse.RemoveRequest{Name: "full", Dir: true}); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Expected ENOTEMPTY, got %v", err)
+	}
+	if err := live.Remove(ctx, &fuse.RemoveRequest{Name: "full"}); !errors.Is(err, syscall.EISDIR) {
+		t.Errorf("Expected EISDIR unlinking a directory, got %v", err)
+	}
+	if err := live.Remove(ctx, &fuse.RemoveRequest{Name: "missing", Dir: true}); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Expected ENOENT, got %v", err)
+	}
+
+	// Directories that only exist in the hot cache are removed from it
+	os.MkdirAll(filepath.Join(fsys.HotCache.IncomingDir, "fresh"), 0o755)
+	if err := live.Remove(ctx, &fuse.RemoveRequest{Name: "fresh", Dir: true}); err != nil {
+		t.Errorf("Removing an empty hot cache directory failed: %v", err)
+	}
+
+	snapshots := &Dir{fs: fsys, path: "/snapshots/latest"}
+	if err := snapshots.Remove(ctx, &fuse.RemoveRequest{Name: "full"}); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("Expected EPERM outside /live, got %v", err)
+	}
+}
+
+func TestRename_AcrossBoundaries(t *testing.T) {
+	storage := t.TempDir()
+	created := time.Now().Add(-time.Hour)
+	oldManifest := writeLookupTable(t, filepath.Join(storage, "a"),
+		util.LookupEntry{Name: "x.json", Target: "1-00000-aaa", FileSize: 3, Inode: 42, Modified: created},
+	)
+	newManifest := writeLookupTable(t, filepath.Join(storage, "b"),
+		util.LookupEntry{Name: "y.json", Target: "1-00000-bbb", Modified: created},
+	)
+
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	ctx := context.Background()
+	src := &Dir{fs: fsys, path: "/live/a"}
+	dst := &Dir{fs: fsys, path: "/live/b"}
+	if err := src.Rename(ctx, &fuse.RenameRequest{OldName: "x.json", NewName: "z.json"}, dst); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	entry, ok := fsys.Index.Lookup("b/z.json")
+	if !ok || entry.Target != "1-00000-aaa" || entry.Inode != 42 {
+		t.Errorf("Expected b/z.json to point at the original target, got %+v (found %v)", entry, ok)
+	}
+	if _, ok := fsys.Index.Lookup("a/x.json"); ok {
+		t.Error("Old name should be gone after Rename")
+	}
+
+	fsys.HotCache.processFiles()
+
+	lt, _ := util.ReadLookupTable(newManifest)
+	if last := lt.Get(lt.Len() - 1); last.Name != "z.json" || last.Target != "1-00000-aaa" {
+		t.Errorf("Expected z.json entry in the destination boundary, got %+v", last)
+	}
+	lt, _ = util.ReadLookupTable(oldManifest)
+	if last := lt.Get(lt.Len() - 1); last.Name != "x.json" || last.Target != "" {
+		t.Errorf("Expected x.json tombstone in the source boundary, got %+v", last)
+	}
+	if _, err := os.Stat(filepath.Join(storage, util.WorkDir)); !os.IsNotExist(err) {
+		t.Error("Renaming an archived file should not copy content")
+	}
+
+	before := created.Add(time.Minute)
+	if _, err := fsys.findFileEntryAtTime("/a/x.json", &before); err != nil {
+		t.Errorf("Snapshot before the rename should show the old name: %v", err)
+	}
+}
+
+func TestRename_HotCacheFile(t *testing.T) {
+	storage := t.TempDir()
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	if err := fsys.HotCache.WriteFile("ingest/reading.json.tmp", []byte("{}")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	dir := &Dir{fs: fsys, path: "/live/ingest"}
+	ctx := context.Background()
+	if err := dir.Rename(ctx, &fuse.RenameRequest{OldName: "reading.json.tmp", NewName: "reading.json"}, dir); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	incoming := fsys.HotCache.IncomingDir
+	if _, err := os.Stat(filepath.Join(incoming, "ingest", "reading.json")); err != nil {
+		t.Errorf("Expected renamed file in the hot cache: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(incoming, "ingest", "reading.json.tmp")); !os.IsNotExist(err) {
+		t.Error("Old name should be gone from the hot cache")
+	}
+	if _, err := os.Stat(fsys.HotCache.pendingPath()); !os.IsNotExist(err) {
+		t.Error("Renaming a file only in the hot cache needs no journal entries")
+	}
+
+	if err := dir.Rename(ctx, &fuse.RenameRequest{OldName: "missing", NewName: "other"}, dir); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Expected ENOENT, got %v", err)
+	}
+}
+
+func TestRename_StagedFile(t *testing.T) {
+	fsys := NewFS(t.TempDir())
+	defer fsys.Stop()
+	hc := fsys.HotCache
+
+	// A collection is archiving a.json
+	hc.gcMu.Lock()
+	staged := stageFile(t, hc, "a.json", "staged")
+	done := make(chan error)
+	go func() { done <- hc.Rename("a.json", "b.json") }()
+	select {
+	case err := <-done:
+		t.Fatalf("Rename should wait for the collection, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	hc.archive([]*stagedFile{{stagingPath: staged, relPath: "a.json"}})
+	hc.gcMu.Unlock()
+	if err := <-done; err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	// The new name points at archived content right away
+	node, err := lookupPath(fsys, "/live/b.json")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if got := readNode(t, node); got != "staged" {
+		t.Errorf("Read %q", got)
+	}
+	if _, err := lookupPath(fsys, "/live/a.json"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Expected the old name gone, got %v", err)
+	}
+
+	// A file a failed collection left behind is renamed from incoming
+	stageFile(t, hc, "c.json", "left")
+	if err := hc.Rename("c.json", "d.json"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if got := hotContent(t, fsys, "d.json"); got != "left" {
+		t.Errorf("Expected d.json in incoming, got %q", got)
+	}
+}
+
+func TestRename_Directory(t *testing.T) {
+	storage := t.TempDir()
+	created := time.Now().Add(-time.Hour)
+	writeLookupTable(t, storage,
+		util.LookupEntry{Name: "old/one.json", Target: "1-00000-aaa", Modified: created},
+		util.LookupEntry{Name: "old/sub/two.json", Target: "1-00000-bbb", Modified: created},
+		util.LookupEntry{Name: "busy/three.json", Target: "1-00000-ccc", Modified: created},
+	)
+
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	live := &Dir{fs: fsys, path: "/live"}
+	ctx := context.Background()
+	if err := live.Rename(ctx, &fuse.RenameRequest{OldName: "old", NewName: "busy"}, live); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Expected ENOTEMPTY renaming onto a non-empty directory, got %v", err)
+	}
+	if err := live.Rename(ctx, &fuse.RenameRequest{OldName: "old", NewName: "new"}, live); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	for p, target := range map[string]string{"new/one.json": "1-00000-aaa", "new/sub/two.json": "1-00000-bbb"} {
+		if entry, ok := fsys.Index.Lookup(p); !ok || entry.Target != target {
+			t.Errorf("Expected %s -> %s, got %+v (found %v)", p, target, entry, ok)
+		}
+	}
+	if fsys.Index.IsDir("old") {
+		t.Error("Old directory should be gone after Rename")
+	}
+}
+
+func TestMkdir_EmptyDirectories(t *testing.T) {
+	storage := t.TempDir()
+	manifestPath := writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "loc1/reading.json", Target: "1-00000-aaa", Modified: time.Now().Add(-time.Hour)},
+	)
+
+	fsys := NewFS(storage)
+
+	ctx := context.Background()
+	sensors := &Dir{fs: fsys, path: "/live/sensors"}
+	if _, err := sensors.Mkdir(ctx, &fuse.MkdirRequest{Name: "loc2"}); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, err := sensors.Mkdir(ctx, &fuse.MkdirRequest{Name: "loc2"}); !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("Expected EEXIST, got %v", err)
+	}
+	if _, err := sensors.Mkdir(ctx, &fuse.MkdirRequest{Name: "loc1"}); !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("Expected EEXIST for an archived directory, got %v", err)
+	}
+
+	// The directory survives a remount and the journal being applied
+	fsys.Stop()
+	fsys = NewFS(storage)
+	defer fsys.Stop()
+	fsys.HotCache.processFiles()
+
+	sensors = &Dir{fs: fsys, path: "/live/sensors"}
+	node, err := sensors.Lookup(ctx, "loc2")
+	if err != nil {
+		t.Fatalf("Lookup of an empty directory failed: %v", err)
+	}
+	if _, ok := node.(*Dir); !ok {
+		t.Errorf("Expected a directory node, got %T", node)
+	}
+	dirents, _ := sensors.ReadDirAll(ctx)
+	if len(dirents) != 2 || dirents[1].Name != "loc2" || dirents[1].Type != fuse.DT_Dir {
+		t.Errorf("Expected loc1 and loc2 listed as directories, got %+v", dirents)
+	}
+
+	lt, _ := util.ReadLookupTable(manifestPath)
+	if last := lt.Get(lt.Len() - 1); last.Name != "loc2" || !last.IsDir() {
+		t.Errorf("Expected a directory entry for loc2 in the boundary, got %+v", last)
+	}
+	if _, ok := fsys.Index.Lookup("sensors/loc2"); ok {
+		t.Error("A directory entry should not resolve as a file")
+	}
+
+	latest := &Dir{fs: fsys, path: "/snapshots/latest"}
+	if _, err := latest.Lookup(ctx, "sensors"); err != nil {
+		t.Errorf("Snapshot should show the directory: %v", err)
+	}
+
+	// Empty recorded directories can be removed, non-empty ones can't
+	if err := sensors.Remove(ctx, &fuse.RemoveRequest{Name: "loc1", Dir: true}); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("Expected ENOTEMPTY, got %v", err)
+	}
+	if err := sensors.Remove(ctx, &fuse.RemoveRequest{Name: "loc2", Dir: true}); err != nil {
+		t.Fatalf("Rmdir failed: %v", err)
+	}
+	if fsys.Index.IsDir("sensors/loc2") {
+		t.Error("Removed directory should be gone")
+	}
+	if _, err := sensors.Lookup(ctx, "loc2"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Expected ENOENT after Rmdir, got %v", err)
+	}
+	if err := sensors.Remove(ctx, &fuse.RemoveRequest{Name: "loc2", Dir: true}); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Expected ENOENT removing a removed directory, got %v", err)
+	}
+}
+
+func TestRename_EmptyDirectory(t *testing.T) {
+	fsys := NewFS(t.TempDir())
+	defer fsys.Stop()
+
+	ctx := context.Background()
+	live := &Dir{fs: fsys, path: "/live"}
+	live.Mkdir(ctx, &fuse.MkdirRequest{Name: "empty"})
+	live.Mkdir(ctx, &fuse.MkdirRequest{Name: "target"})
+
+	// An empty directory may be replaced
+	if err := live.Rename(ctx, &fuse.RenameRequest{OldName: "empty", NewName: "target"}, live); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if fsys.Index.IsDir("empty") || !fsys.Index.IsDir("target") {
+		t.Error("Expected the directory entry to move to the new name")
+	}
+}
@@ -0,0 +1,98 @@
+package djafs
+
+import (
+	"os"
+	"runtime"
+	"time"
+)
+
+// Options configures a filesystem instance
+type Options struct {
+	// LookupCacheBytes bounds the memory used by cached lookup tables
+	LookupCacheBytes int64
+	// ContentCacheBytes bounds the memory used by cached decompressed file
+	// content. Files larger than an eighth of it are always streamed.
+	ContentCacheBytes int64
+	// OpenArchives is how many idle archives are kept open for reuse
+	OpenArchives int
+	// WriteBufferBytes bounds the memory buffering the content of each file
+	// open for writing. Larger files are written straight to a temporary
+	// file in the hot cache; 0 writes every file that way.
+	WriteBufferBytes int64
+	// SyncOnFlush makes every close of a written file as durable as fsync:
+	// the content and its directory entry are on stable storage before
+	// close returns
+	SyncOnFlush bool
+	// GCInterval is how often the garbage collector checks whether the hot
+	// cache is due for packing
+	GCInterval time.Duration
+	// The garbage collector packs the hot cache into archives once it holds
+	// PackFiles files or PackBytes bytes, right away, or its oldest file is
+	// PackAge old. Until then files are served from the hot cache. 0
+	// disables a trigger; with all three disabled every check packs.
+	PackFiles int
+	PackBytes int64
+	PackAge   time.Duration
+	// MinPackAge leaves files younger than it in the hot cache for a later
+	// collection, so files rewritten in quick succession are packed once
+	MinPackAge time.Duration
+	// QuietWindows defer packing while any of them is open. Only writers
+	// waiting for room in a full hot cache (see QueueFiles) override them.
+	QuietWindows []QuietWindow
+	// GCWorkers is how many files a collection hashes and copies at once
+	GCWorkers int
+	// QueueFiles bounds the files waiting in the hot cache to be archived.
+	// Writing a new file beyond it wakes the garbage collector and waits
+	// up to QueueWait for room, then fails with EAGAIN. 0 disables the
+	// limit.
+	QueueFiles int
+	QueueWait  time.Duration
+	// QuotaFiles and QuotaBytes bound what the hot cache holds, with
+	// QuotaBytes counting large files still being written. A write that
+	// would exceed either fails with ENOSPC. Usage above the
+	// QuotaHighWater fraction of either is logged as a warning. 0 disables
+	// a quota.
+	QuotaFiles     int
+	QuotaBytes     int64
+	QuotaHighWater float64
+	// ReadOnly serves the archived state without a hot cache or garbage
+	// collector, and never writes to the storage directory
+	ReadOnly bool
+	// At, if set, serves only the snapshot at that instant as the root.
+	// Snapshot mounts are always read-only.
+	At time.Time
+	// Uid and Gid own files and directories that don't record an owner
+	Uid, Gid uint32
+	// Umask clears permission bits of files and directories that don't
+	// record a mode, which otherwise get 0666 and 0777
+	Umask os.FileMode
+}
+
+// DefaultOptions returns the options used by NewFS
+func DefaultOptions() Options {
+	return Options{
+		LookupCacheBytes:  64 << 20,
+		ContentCacheBytes: 256 << 20,
+		OpenArchives:      64,
+		WriteBufferBytes:  8 << 20,
+		GCInterval:        30 * time.Second,
+		PackFiles:         1000,
+		PackBytes:         64 << 20,
+		PackAge:           10 * time.Minute,
+		GCWorkers:         runtime.NumCPU(),
+		QueueFiles:        100000,
+		QueueWait:         10 * time.Second,
+		QuotaHighWater:    0.9,
+		Uid:               uint32(os.Getuid()),
+		Gid:               uint32(os.Getgid()),
+		Umask:             0o022,
+	}
+}
+
+// Stats is a snapshot of the filesystem's runtime counters
+type Stats struct {
+	LookupCache  CacheStats
+	ContentCache CacheStats
+	HotCache     HotCacheStats
+	OpenArchives int // Archives the reader pool holds open
+}
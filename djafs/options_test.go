@@ -0,0 +1,104 @@
+package djafs
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+func TestReadOnly_LeavesStorageUntouched(t *testing.T) {
+	storage := t.TempDir()
+	target := util.HashPathFromHash(fmt.Sprintf("%064x", 1))
+	writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "a.json", Target: target, FileSize: 2, Modified: time.Now()},
+	)
+	writeTestArchive(t, filepath.Join(storage, "sensors", "1-00000.djfz"), map[string][]byte{target: []byte("{}")}, zip.Deflate)
+
+	opts := DefaultOptions()
+	opts.ReadOnly = true
+	fsys := NewFSWithOptions(storage, opts)
+
+	ctx := context.Background()
+	node, err := lookupPath(fsys, "/live/sensors/a.json")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if _, err := (&Dir{fs: fsys, path: "/live/sensors"}).ReadDirAll(ctx); err != nil {
+		t.Errorf("ReadDirAll failed: %v", err)
+	}
+	if got := readNode(t, node); got != "{}" {
+		t.Errorf("Read %q", got)
+	}
+
+	live := &Dir{fs: fsys, path: "/live"}
+	if _, _, err := live.Create(ctx, &fuse.CreateRequest{Name: "new.json"}, &fuse.CreateResponse{}); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Expected EROFS from Create, got %v", err)
+	}
+	if _, err := live.Mkdir(ctx, &fuse.MkdirRequest{Name: "dir"}); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Expected EROFS from Mkdir, got %v", err)
+	}
+	if err := live.Remove(ctx, &fuse.RemoveRequest{Name: "sensors", Dir: true}); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Expected EROFS from Remove, got %v", err)
+	}
+	open := &fuse.OpenRequest{Flags: fuse.OpenReadWrite}
+	if _, err := node.(*File).Open(ctx, open, &fuse.OpenResponse{}); !errors.Is(err, syscall.EROFS) {
+		t.Errorf("Expected EROFS from Open, got %v", err)
+	}
+	fsys.Stop()
+
+	// Only the lookup table and archive written above may exist
+	for _, name := range []string{"hot_cache", PathIndexFile, InodeMapFile, util.TargetIndexFile, util.TargetJournalFile} {
+		if _, err := os.Stat(filepath.Join(storage, name)); !os.IsNotExist(err) {
+			t.Errorf("Read-only mount created %s", name)
+		}
+	}
+}
+
+func TestSnapshotMount(t *testing.T) {
+	storage := t.TempDir()
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "a.json", Target: "1-00000-aaa", Modified: day.Add(time.Hour)},
+		util.LookupEntry{Name: "a.json", Target: "1-00000-bbb", Modified: day.Add(3 * time.Hour)},
+		util.LookupEntry{Name: "b.json", Target: "1-00000-ccc", Modified: day.Add(4 * time.Hour)},
+	)
+
+	opts := DefaultOptions()
+	opts.At = day.Add(2 * time.Hour)
+	fsys := NewFSWithOptions(storage, opts)
+	defer fsys.Stop()
+
+	if fsys.HotCache != nil {
+		t.Error("Snapshot mounts should be read-only")
+	}
+
+	ctx := context.Background()
+	root, _ := fsys.Root()
+	sensors, err := root.(*Dir).Lookup(ctx, "sensors")
+	if err != nil {
+		t.Fatalf("Lookup sensors failed: %v", err)
+	}
+	dirents, _ := sensors.(*Dir).ReadDirAll(ctx)
+	if len(dirents) != 1 || dirents[0].Name != "a.json" {
+		t.Errorf("Expected only a.json at the snapshot time, got %+v", dirents)
+	}
+	node, err := sensors.(*Dir).Lookup(ctx, "a.json")
+	if err != nil {
+		t.Fatalf("Lookup a.json failed: %v", err)
+	}
+	if target := node.(*File).entry.Target; target != "1-00000-aaa" {
+		t.Errorf("Expected the revision at the snapshot time, got %s", target)
+	}
+	if _, err := root.(*Dir).Lookup(ctx, "live"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Snapshot mounts should not expose /live, got %v", err)
+	}
+}
@@ -0,0 +1,157 @@
+package djafs
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// hotFile is a file found in the hot cache
+type hotFile struct {
+	path string // Absolute path in incoming or staging
+	info os.FileInfo
+}
+
+// Stat returns the newest hot cache copy of the file at logical path p,
+// from incoming before staging. A nil hot cache, as in read-only mounts,
+// holds nothing.
+func (hc *HotCache) Stat(p string) (hotFile, bool) {
+	if hc == nil {
+		return hotFile{}, false
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.stat(p)
+}
+
+// stat is Stat without locking. The caller must hold hc.mu.
+func (hc *HotCache) stat(p string) (hotFile, bool) {
+	if reserved(p) {
+		return hotFile{}, false
+	}
+	for _, dir := range []string{hc.IncomingDir, hc.StagingDir} {
+		hotPath := filepath.Join(dir, p)
+		if info, err := os.Stat(hotPath); err == nil && info.Mode().IsRegular() {
+			return hotFile{path: hotPath, info: info}, true
+		}
+	}
+	return hotFile{}, false
+}
+
+// Open opens the newest hot cache copy of the file at logical path p.
+// The open file stays readable when the garbage collector moves or removes it.
+func (hc *HotCache) Open(p string) (*os.File, error) {
+	if hc == nil {
+		return nil, syscall.ENOENT
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	hf, ok := hc.stat(p)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+	return os.Open(hf.path)
+}
+
+// IsDir reports whether the hot cache holds a directory at logical path p
+func (hc *HotCache) IsDir(p string) bool {
+	if hc == nil {
+		return false
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.isHotDir(p)
+}
+
+// ReadDir lists the hot cache children of logical directory dir. Files are
+// the newest copy, directories have a nil info.
+func (hc *HotCache) ReadDir(dir string) map[string]*hotFile {
+	if hc == nil {
+		return nil
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	children := make(map[string]*hotFile)
+	// Staging first so that incoming copies replace older staged ones
+	for _, root := range []string{hc.StagingDir, hc.IncomingDir} {
+		dirents, err := os.ReadDir(filepath.Join(root, dir))
+		if err != nil {
+			continue
+		}
+		for _, d := range dirents {
+			if reserved(path.Join(dir, d.Name())) {
+				continue
+			}
+			if d.IsDir() {
+				if _, ok := children[d.Name()]; !ok {
+					children[d.Name()] = nil
+				}
+				continue
+			}
+			info, err := d.Info()
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			children[d.Name()] = &hotFile{path: filepath.Join(root, dir, d.Name()), info: info}
+		}
+	}
+	return children
+}
+
+// hotWins reports whether a hot cache copy written at hf supersedes what the
+// index holds for logical path p, tombstones included
+func (fs *FS) hotWins(p string, hf hotFile) bool {
+	current, ok := fs.Index.Current(p)
+	return !ok || hf.info.ModTime().After(current.Modified)
+}
+
+// hotEntry describes a hot cache file as a lookup entry without a target
+func hotEntry(p string, hf hotFile) util.LookupEntry {
+	return util.LookupEntry{
+		FileSize: hf.info.Size(),
+		Modified: hf.info.ModTime(),
+		Name:     p,
+	}
+}
+
+// liveChildren merges the index and the hot cache children of a /live
+// directory, newest version winning
+func (fs *FS) liveChildren(dir string) []IndexDirent {
+	children := fs.Index.ReadDir(dir)
+	seen := make(map[string]int, len(children))
+	for i, child := range children {
+		seen[child.Name] = i
+	}
+
+	for name, hf := range fs.HotCache.ReadDir(dir) {
+		p := path.Join(dir, name)
+		i, indexed := seen[name]
+		switch {
+		case hf == nil:
+			if !indexed {
+				children = append(children, IndexDirent{Name: name, IsDir: true})
+			}
+		case indexed && children[i].IsDir:
+			// A directory shadows a file of the same name
+		case fs.hotWins(p, *hf):
+			child := IndexDirent{Name: name, Entry: hotEntry(p, *hf)}
+			if indexed {
+				children[i] = child
+			} else {
+				children = append(children, child)
+			}
+		}
+	}
+
+	slices.SortFunc(children, func(a, b IndexDirent) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return children
+}
@@ -0,0 +1,120 @@
+package djafs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// readNode reads the whole content of a file node through Read
+func readNode(t *testing.T, node fs.Node) string {
+	t.Helper()
+	f, ok := node.(*File)
+	if !ok {
+		t.Fatalf("Expected a file node, got %T", node)
+	}
+	h, err := f.Open(context.Background(), &fuse.OpenRequest{Flags: fuse.OpenReadOnly}, &fuse.OpenResponse{})
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	req := &fuse.ReadRequest{Size: 4096}
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, req.Size)}
+	if err := h.(*FileHandle).Read(context.Background(), req, resp); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	h.(*FileHandle).Release(context.Background(), &fuse.ReleaseRequest{})
+	return string(resp.Data)
+}
+
+func TestLive_ReadYourWrites(t *testing.T) {
+	storage := t.TempDir()
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	if err := fsys.HotCache.WriteFile("sensors/new.json", []byte(`{"v":1}`)); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	ctx := context.Background()
+	live := &Dir{fs: fsys, path: "/live"}
+	dirents, _ := live.ReadDirAll(ctx)
+	if len(dirents) != 1 || dirents[0].Name != "sensors" || dirents[0].Type != fuse.DT_Dir {
+		t.Errorf("Expected the hot cache directory in /live, got %+v", dirents)
+	}
+	if _, err := live.Lookup(ctx, "sensors"); err != nil {
+		t.Fatalf("Lookup sensors failed: %v", err)
+	}
+
+	sensors := &Dir{fs: fsys, path: "/live/sensors"}
+	dirents, _ = sensors.ReadDirAll(ctx)
+	if len(dirents) != 1 || dirents[0].Name != "new.json" {
+		t.Errorf("Expected new.json in the listing, got %+v", dirents)
+	}
+	node, err := sensors.Lookup(ctx, "new.json")
+	if err != nil {
+		t.Fatalf("Lookup new.json failed: %v", err)
+	}
+	var attr fuse.Attr
+	node.Attr(ctx, &attr)
+	if attr.Size != 7 {
+		t.Errorf("Expected size 7, got %d", attr.Size)
+	}
+	if got := readNode(t, node); got != `{"v":1}` {
+		t.Errorf("Read %q from the hot cache", got)
+	}
+
+	// Staged files stay visible while the garbage collector works on them
+	staged := filepath.Join(fsys.HotCache.StagingDir, "sensors", "new.json")
+	os.MkdirAll(filepath.Dir(staged), 0o755)
+	os.Rename(filepath.Join(fsys.HotCache.IncomingDir, "sensors", "new.json"), staged)
+	node, err = sensors.Lookup(ctx, "new.json")
+	if err != nil {
+		t.Fatalf("Lookup of a staged file failed: %v", err)
+	}
+	if got := readNode(t, node); got != `{"v":1}` {
+		t.Errorf("Read %q from staging", got)
+	}
+}
+
+func TestLive_NewestVersionWins(t *testing.T) {
+	storage := t.TempDir()
+	writeLookupTable(t, storage,
+		util.LookupEntry{Name: "old.json", Target: "1-00000-aaa", FileSize: 1, Modified: time.Now().Add(-time.Hour)},
+		util.LookupEntry{Name: "newer.json", Target: "1-00000-bbb", FileSize: 1, Modified: time.Now().Add(time.Hour)},
+	)
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	fsys.HotCache.WriteFile("old.json", []byte("rewritten"))
+	fsys.HotCache.WriteFile("newer.json", []byte("stale copy"))
+
+	ctx := context.Background()
+	live := &Dir{fs: fsys, path: "/live"}
+
+	node, err := live.Lookup(ctx, "old.json")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if got := readNode(t, node); got != "rewritten" {
+		t.Errorf("Expected the hot cache copy to win, read %q", got)
+	}
+
+	node, err = live.Lookup(ctx, "newer.json")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	if f := node.(*File); f.isHot || f.entry.Target != "1-00000-bbb" {
+		t.Error("Expected the newer archived entry to win")
+	}
+
+	dirents, _ := live.ReadDirAll(ctx)
+	if len(dirents) != 2 {
+		t.Errorf("Expected each file listed once, got %+v", dirents)
+	}
+}
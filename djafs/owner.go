@@ -0,0 +1,226 @@
+package djafs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// OwnersFile holds the mode and owner of files still in the hot cache until
+// the garbage collector copies them into their lookup entries. It is a
+// lookup table whose entry names are logical paths.
+const OwnersFile = "owners.djfl"
+
+// Permissions before the umask, as open(2) and mkdir(2) default to them
+const (
+	defaultFileMode = 0o666
+	defaultDirMode  = 0o777
+)
+
+// toStMode converts m to a POSIX st_mode for a file or directory
+func toStMode(m os.FileMode, dir bool) uint32 {
+	mode := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= syscall.S_ISUID
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= syscall.S_ISGID
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= syscall.S_ISVTX
+	}
+	if dir {
+		return mode | syscall.S_IFDIR
+	}
+	return mode | syscall.S_IFREG
+}
+
+// fromStMode converts a POSIX st_mode to an os.FileMode
+func fromStMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0o777)
+	if mode&syscall.S_ISUID != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&syscall.S_ISGID != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&syscall.S_ISVTX != 0 {
+		m |= os.ModeSticky
+	}
+	if mode&syscall.S_IFMT == syscall.S_IFDIR {
+		m |= os.ModeDir
+	}
+	return m
+}
+
+// copyOwner copies the mode and owner of src to dst
+func copyOwner(dst *util.LookupEntry, src util.LookupEntry) {
+	dst.Mode, dst.Uid, dst.Gid = src.Mode, src.Uid, src.Gid
+}
+
+// owner returns entry, with the Uid, Gid and Umask of the mount as mode and
+// owner if it doesn't record them, as entries of converted archives don't
+func (fs *FS) owner(entry util.LookupEntry, dir bool) util.LookupEntry {
+	if entry.HasOwner() {
+		return entry
+	}
+	var opts Options
+	if fs != nil {
+		opts = fs.Options
+	}
+	perm := os.FileMode(defaultFileMode)
+	if dir {
+		perm = defaultDirMode
+	}
+	entry.Mode = toStMode(perm&^opts.Umask, dir)
+	entry.Uid, entry.Gid = opts.Uid, opts.Gid
+	return entry
+}
+
+// fillOwner sets the mode and owner of a from entry or the mount defaults
+func (fs *FS) fillOwner(a *fuse.Attr, entry util.LookupEntry, dir bool) {
+	entry = fs.owner(entry, dir)
+	a.Mode = fromStMode(entry.Mode)
+	a.Uid, a.Gid = entry.Uid, entry.Gid
+}
+
+// changesOwner reports whether a setattr request is a chmod or chown
+func changesOwner(req *fuse.SetattrRequest) bool {
+	return req.Valid.Mode() || req.Valid.Uid() || req.Valid.Gid()
+}
+
+// applyOwner applies the chmod and chown of a setattr request to entry,
+// starting from the mount defaults if it doesn't record a mode and owner
+func (fs *FS) applyOwner(entry util.LookupEntry, req *fuse.SetattrRequest, dir bool) util.LookupEntry {
+	entry = fs.owner(entry, dir)
+	if req.Valid.Mode() {
+		entry.Mode = toStMode(req.Mode, dir)
+	}
+	if req.Valid.Uid() {
+		entry.Uid = req.Uid
+	}
+	if req.Valid.Gid() {
+		entry.Gid = req.Gid
+	}
+	return entry
+}
+
+// ownersPath returns the location of the hot cache owners table
+func (hc *HotCache) ownersPath() string {
+	return filepath.Join(filepath.Dir(hc.IncomingDir), OwnersFile)
+}
+
+// restoreOwners loads the owners recorded before the last unmount
+func (hc *HotCache) restoreOwners() {
+	hc.owners = make(map[string]util.LookupEntry)
+	lt, err := util.ReadLookupTable(hc.ownersPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Error reading hot cache owners: %v\n", err)
+		}
+		return
+	}
+	for entry := range lt.Iterate {
+		hc.owners[entry.Name] = entry
+	}
+}
+
+// saveOwners persists the owners table. The caller must hold hc.mu.
+func (hc *HotCache) saveOwners() error {
+	var lt util.LookupTable
+	for _, entry := range hc.owners {
+		lt.Add(entry)
+	}
+	if err := replaceTable(hc.ownersPath(), lt); err != nil {
+		return fmt.Errorf("failed to write hot cache owners: %w", err)
+	}
+	return nil
+}
+
+// Owner returns the mode and owner of the hot cache copy of the file at
+// logical path p: those recorded for it, else those of its archived
+// version. A nil hot cache holds nothing.
+func (hc *HotCache) Owner(p string) (util.LookupEntry, bool) {
+	if hc == nil {
+		return util.LookupEntry{}, false
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return hc.ownerOf(p)
+}
+
+// ownerOf is Owner without locking. The caller must hold hc.mu.
+func (hc *HotCache) ownerOf(p string) (util.LookupEntry, bool) {
+	if owner, ok := hc.owners[p]; ok {
+		return owner, true
+	}
+	current, ok := hc.fs.Index.Current(p)
+	if !ok || current.Target == "" || current.IsDir() || !current.HasOwner() {
+		return util.LookupEntry{}, false
+	}
+	return current, true
+}
+
+// SetOwner records the mode and owner of the file or directory at logical
+// path p. Hot cache files keep them until they are archived, archived files
+// and directories get a journal entry.
+func (hc *HotCache) SetOwner(p string, owner util.LookupEntry) error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	if hf, hot := hc.stat(p); hot && hc.fs.hotWins(p, hf) {
+		entry := util.LookupEntry{Name: p}
+		copyOwner(&entry, owner)
+		hc.owners[p] = entry
+		return hc.saveOwners()
+	}
+
+	// Keeping Modified leaves snapshots alone, the later entry wins the tie
+	if current, ok := hc.fs.Index.Current(p); ok && current.Target != "" {
+		copyOwner(&current, owner)
+		return hc.recordEntries(current)
+	}
+
+	// Directories only implied by their files get recorded
+	if hc.fs.Index.IsDir(p) || hc.isHotDir(p) {
+		entry := util.LookupEntry{Modified: time.Now(), Name: p, Target: util.DirTarget}
+		copyOwner(&entry, owner)
+		return hc.recordEntries(entry)
+	}
+	return syscall.ENOENT
+}
+
+// forgetOwner drops the recorded owner of p. The caller must hold hc.mu.
+func (hc *HotCache) forgetOwner(p string) error {
+	if _, ok := hc.owners[p]; !ok {
+		return nil
+	}
+	delete(hc.owners, p)
+	return hc.saveOwners()
+}
+
+// moveOwners renames the recorded owners of oldPath and everything below
+// it. The caller must hold hc.mu.
+func (hc *HotCache) moveOwners(oldPath, newPath string) error {
+	var moved []util.LookupEntry
+	for p, owner := range hc.owners {
+		if rest, ok := strings.CutPrefix(p, oldPath); ok && (rest == "" || strings.HasPrefix(rest, "/")) {
+			delete(hc.owners, p)
+			owner.Name = newPath + rest
+			moved = append(moved, owner)
+		}
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+	for _, owner := range moved {
+		hc.owners[owner.Name] = owner
+	}
+	return hc.saveOwners()
+}
@@ -0,0 +1,108 @@
+package djafs
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+func TestOwner_PreservedFromCreateToArchive(t *testing.T) {
+	storage := t.TempDir()
+	created := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+	writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "old.json", Target: "1-00000-aaa", Modified: created},
+	)
+
+	opts := DefaultOptions()
+	opts.Uid, opts.Gid, opts.Umask = 1234, 5678, 0o027
+	fsys := NewFSWithOptions(storage, opts)
+	defer fsys.Stop()
+
+	ctx := context.Background()
+	checkAttr := func(node interface {
+		Attr(context.Context, *fuse.Attr) error
+	}, mode os.FileMode, uid, gid uint32) {
+		t.Helper()
+		var a fuse.Attr
+		if err := node.Attr(ctx, &a); err != nil {
+			t.Fatalf("Attr failed: %v", err)
+		}
+		if a.Mode != mode || a.Uid != uid || a.Gid != gid {
+			t.Errorf("Expected %v %d:%d, got %v %d:%d", mode, uid, gid, a.Mode, a.Uid, a.Gid)
+		}
+	}
+
+	// Converted entries without an owner get the mount defaults
+	old, err := lookupPath(fsys, "/live/sensors/old.json")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	checkAttr(old.(*File), 0o640, 1234, 5678)
+	checkAttr(&Dir{fs: fsys, path: "/live/sensors"}, os.ModeDir|0o750, 1234, 5678)
+
+	// chown of an archived file is journaled without a new revision
+	if err := old.(*File).Setattr(ctx, &fuse.SetattrRequest{Valid: fuse.SetattrUid, Uid: 42}, &fuse.SetattrResponse{}); err != nil {
+		t.Fatalf("Setattr failed: %v", err)
+	}
+	if entry, _ := fsys.Index.Lookup("sensors/old.json"); entry.Uid != 42 || entry.Gid != 5678 || !entry.Modified.Equal(created) {
+		t.Errorf("Expected uid 42 at the same revision, got %+v", entry)
+	}
+
+	// New files keep the mode and owner of the request
+	dir := &Dir{fs: fsys, path: "/live/sensors"}
+	req := &fuse.CreateRequest{Name: "new.json", Mode: 0o600}
+	req.Header.Uid, req.Header.Gid = 1000, 100
+	_, handle, err := dir.Create(ctx, req, &fuse.CreateResponse{})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	file := handle.(*FileHandle)
+	file.Write(ctx, &fuse.WriteRequest{Data: []byte("{}")}, &fuse.WriteResponse{})
+	if err := file.Flush(ctx, &fuse.FlushRequest{}); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	hot, err := dir.Lookup(ctx, "new.json")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	checkAttr(hot.(*File), 0o600, 1000, 100)
+	chmod := &fuse.SetattrRequest{Valid: fuse.SetattrMode, Mode: 0o644}
+	if err := hot.(*File).Setattr(ctx, chmod, &fuse.SetattrResponse{}); err != nil {
+		t.Fatalf("Setattr failed: %v", err)
+	}
+
+	// The garbage collector moves the owner into the lookup entry
+	hc := fsys.HotCache
+	staged := filepath.Join(hc.StagingDir, "sensors", "new.json")
+	os.MkdirAll(filepath.Dir(staged), 0o755)
+	if err := os.Rename(filepath.Join(hc.IncomingDir, "sensors", "new.json"), staged); err != nil {
+		t.Fatalf("Rename to staging failed: %v", err)
+	}
+	hc.archive([]*stagedFile{{stagingPath: staged, relPath: "sensors/new.json"}})
+
+	entry, ok := fsys.Index.Lookup("sensors/new.json")
+	if !ok {
+		t.Fatal("Expected sensors/new.json to be archived")
+	}
+	if fromStMode(entry.Mode) != 0o644 || entry.Uid != 1000 || entry.Gid != 100 {
+		t.Errorf("Expected 0644 1000:100 in the lookup entry, got %+v", entry)
+	}
+	if _, err := os.Stat(hc.ownersPath()); !os.IsNotExist(err) {
+		t.Error("Owners of archived files should leave the hot cache")
+	}
+
+	// Directories keep the mode they are made with
+	mkdir := &fuse.MkdirRequest{Name: "private", Mode: os.ModeDir | 0o700}
+	mkdir.Header.Uid, mkdir.Header.Gid = 1000, 100
+	sub, err := dir.Mkdir(ctx, mkdir)
+	if err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	checkAttr(sub.(*Dir), os.ModeDir|0o700, 1000, 100)
+}
@@ -0,0 +1,229 @@
+package djafs
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// archivePool shares open .djfz archives between reads.
+//
+// Each archive's central directory is parsed once and kept as a name to
+// *zip.File map. Handles are reference counted: Acquire hands out a handle
+// and Release returns it. Every Acquire stats the archive, and when it was
+// replaced (PackWorkDir writes a new file and renames it into place) the old
+// handle is dropped from the pool and closed once its last reader releases
+// it, so in-flight reads finish against the archive they started on. The
+// garbage collector invalidates the archives it packs, so idle handles of
+// replaced archives are closed right away.
+//
+// A nil pool opens a fresh handle on every Acquire and closes it on Release.
+type archivePool struct {
+	handles map[string]*pooledArchive
+	maxIdle int // Unreferenced handles kept open
+	mu      sync.Mutex
+}
+
+// pooledArchive is an open archive shared through an archivePool
+type pooledArchive struct {
+	path     string
+	raw      *os.File
+	files    map[string]*zip.File
+	info     os.FileInfo // Identity of the file when it was opened
+	refs     int
+	stale    bool // Dropped from the pool, close when refs reaches zero
+	lastUsed time.Time
+}
+
+// newArchivePool creates a pool keeping at most maxIdle unreferenced
+// archives open
+func newArchivePool(maxIdle int) *archivePool {
+	return &archivePool{
+		handles: make(map[string]*pooledArchive),
+		maxIdle: maxIdle,
+	}
+}
+
+// Acquire returns an open handle for archivePath. The caller must Release it.
+func (p *archivePool) Acquire(archivePath string) (*pooledArchive, error) {
+	info, err := os.Stat(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+	if p == nil {
+		return openPooledArchive(archivePath)
+	}
+
+	p.mu.Lock()
+	if a, ok := p.handles[archivePath]; ok {
+		if a.sameFile(info) {
+			a.refs++
+			p.mu.Unlock()
+			return a, nil
+		}
+		p.drop(a)
+	}
+	p.mu.Unlock()
+
+	// Open outside the lock, parsing a central directory can be slow
+	a, err := openPooledArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if existing, ok := p.handles[archivePath]; ok {
+		if existing.sameFile(a.info) {
+			// Another reader opened the same file first
+			a.close()
+			existing.refs++
+			return existing, nil
+		}
+		p.drop(existing)
+	}
+	p.handles[archivePath] = a
+	return a, nil
+}
+
+// Release returns a handle obtained from Acquire
+func (p *archivePool) Release(a *pooledArchive) {
+	if p == nil {
+		a.close()
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a.refs--
+	a.lastUsed = time.Now()
+	if a.refs > 0 {
+		return
+	}
+	if a.stale {
+		a.close()
+		return
+	}
+	p.trimIdle()
+}
+
+// Invalidate drops archivePath from the pool, e.g. after it was rewritten
+func (p *archivePool) Invalidate(archivePath string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if a, ok := p.handles[archivePath]; ok {
+		p.drop(a)
+	}
+}
+
+// Close drops every archive. Handles still in use close on their last Release.
+func (p *archivePool) Close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, a := range p.handles {
+		p.drop(a)
+	}
+}
+
+// Open returns the number of archives the pool holds open
+func (p *archivePool) Open() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.handles)
+}
+
+// drop removes a from the pool, closing it unless it's in use.
+// The caller must hold p.mu.
+func (p *archivePool) drop(a *pooledArchive) {
+	if p.handles[a.path] == a {
+		delete(p.handles, a.path)
+	}
+	a.stale = true
+	if a.refs == 0 {
+		a.close()
+	}
+}
+
+// trimIdle closes the least recently used unreferenced archives beyond
+// maxIdle. The caller must hold p.mu.
+func (p *archivePool) trimIdle() {
+	for {
+		var idle int
+		var oldest *pooledArchive
+		for _, a := range p.handles {
+			if a.refs > 0 {
+				continue
+			}
+			idle++
+			if oldest == nil || a.lastUsed.Before(oldest.lastUsed) {
+				oldest = a
+			}
+		}
+		if idle <= p.maxIdle {
+			return
+		}
+		p.drop(oldest)
+	}
+}
+
+// openPooledArchive opens an archive and indexes its members by name
+func openPooledArchive(archivePath string) (*pooledArchive, error) {
+	raw, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+
+	info, err := raw.Stat()
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+
+	zr, err := zip.NewReader(raw, info.Size())
+	if err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("failed to open archive %s: %w", archivePath, err)
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	return &pooledArchive{
+		path:     archivePath,
+		raw:      raw,
+		files:    files,
+		info:     info,
+		refs:     1,
+		lastUsed: time.Now(),
+	}, nil
+}
+
+// File returns the archive member called name
+func (a *pooledArchive) File(name string) (*zip.File, bool) {
+	f, ok := a.files[name]
+	return f, ok
+}
+
+// sameFile reports whether info describes the file this handle has open
+func (a *pooledArchive) sameFile(info os.FileInfo) bool {
+	return os.SameFile(a.info, info) &&
+		a.info.Size() == info.Size() &&
+		a.info.ModTime().Equal(info.ModTime())
+}
+
+func (a *pooledArchive) close() {
+	a.raw.Close()
+}
@@ -0,0 +1,130 @@
+package djafs
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readPooledMember(t *testing.T, a *pooledArchive, name string) []byte {
+	t.Helper()
+	f, ok := a.File(name)
+	if !ok {
+		t.Fatalf("Member %s not found", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", name, err)
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return content
+}
+
+func TestArchivePool_SharesHandles(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "1-00000.djfz")
+	writeTestArchive(t, archivePath, map[string][]byte{"a": []byte("alpha"), "b": []byte("beta")}, zip.Deflate)
+
+	pool := newArchivePool(4)
+	first, err := pool.Acquire(archivePath)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	second, err := pool.Acquire(archivePath)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if first != second {
+		t.Error("Expected both reads to share one handle")
+	}
+	if got := readPooledMember(t, second, "b"); string(got) != "beta" {
+		t.Errorf("Read %q, want beta", got)
+	}
+
+	pool.Release(first)
+	pool.Release(second)
+	if pool.Open() != 1 {
+		t.Errorf("Expected idle handle to stay open, pool holds %d", pool.Open())
+	}
+}
+
+func TestArchivePool_ReplacedArchive(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "1-00000.djfz")
+	oldContent := testContent(64 * 1024)
+	writeTestArchive(t, archivePath, map[string][]byte{"member": oldContent}, zip.Deflate)
+
+	pool := newArchivePool(4)
+	reader, err := openMember(pool, archivePath, "member")
+	if err != nil {
+		t.Fatalf("openMember failed: %v", err)
+	}
+	old := reader.archive
+
+	// Replace the archive the way PackWorkDir does
+	tmpPath := archivePath + ".tmp"
+	writeTestArchive(t, tmpPath, map[string][]byte{"member": []byte("repacked")}, zip.Deflate)
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	fresh, err := pool.Acquire(archivePath)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	if fresh == old {
+		t.Fatal("Expected a new handle for the replaced archive")
+	}
+	if got := readPooledMember(t, fresh, "member"); string(got) != "repacked" {
+		t.Errorf("Read %q from replaced archive, want repacked", got)
+	}
+	pool.Release(fresh)
+
+	// The in-flight reader still sees the archive it opened
+	buf := make([]byte, 1024)
+	n, err := reader.ReadAt(buf, 4096)
+	if err != nil {
+		t.Fatalf("ReadAt on old handle failed: %v", err)
+	}
+	if !bytes.Equal(buf[:n], oldContent[4096:4096+1024]) {
+		t.Error("Old handle returned wrong data")
+	}
+
+	reader.Close()
+	if _, err := old.raw.Stat(); err == nil {
+		t.Error("Old handle should be closed after its last release")
+	}
+	if pool.Open() != 1 {
+		t.Errorf("Expected only the new handle in the pool, got %d", pool.Open())
+	}
+}
+
+func TestArchivePool_TrimsIdleHandles(t *testing.T) {
+	dir := t.TempDir()
+	pool := newArchivePool(2)
+
+	for i := range 4 {
+		archivePath := filepath.Join(dir, fmt.Sprintf("%d-00000.djfz", i))
+		writeTestArchive(t, archivePath, map[string][]byte{"m": []byte("x")}, zip.Store)
+		a, err := pool.Acquire(archivePath)
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		pool.Release(a)
+	}
+
+	if pool.Open() != 2 {
+		t.Errorf("Expected 2 idle handles, pool holds %d", pool.Open())
+	}
+
+	pool.Close()
+	if pool.Open() != 0 {
+		t.Errorf("Expected empty pool after Close, got %d", pool.Open())
+	}
+}
@@ -0,0 +1,87 @@
+package djafs
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// HotCacheStats is a snapshot of the hot cache's usage
+type HotCacheStats struct {
+	Files      int64 // Files waiting to be archived
+	Bytes      int64
+	Spilled    int64 // Bytes of open files being written to temporary files
+	QuotaFiles int   // 0 if unlimited
+	QuotaBytes int64
+	Rejected   uint64 // Writes refused with ENOSPC
+}
+
+// Stats returns the current usage. A nil hot cache, as in read-only
+// mounts, holds nothing.
+func (hc *HotCache) Stats() HotCacheStats {
+	if hc == nil {
+		return HotCacheStats{}
+	}
+	return HotCacheStats{
+		Files:      hc.backlog.Load(),
+		Bytes:      hc.backlogSize.Load(),
+		Spilled:    hc.spilled.Load(),
+		QuotaFiles: hc.fs.Options.QuotaFiles,
+		QuotaBytes: hc.fs.Options.QuotaBytes,
+		Rejected:   hc.rejected.Load(),
+	}
+}
+
+// fits checks the quota for a write adding files and bytes to the hot
+// cache, so a garbage collector that falls behind can't fill the disk.
+// Temporary files of open handles count against the bytes. The
+// caller must hold hc.mu.
+func (hc *HotCache) fits(files, bytes int64) error {
+	opts := hc.fs.Options
+	if files > 0 && opts.QuotaFiles > 0 && hc.backlog.Load()+files > int64(opts.QuotaFiles) ||
+		bytes > 0 && opts.QuotaBytes > 0 && hc.backlogSize.Load()+hc.spilled.Load()+bytes > opts.QuotaBytes {
+		return hc.reject()
+	}
+	return nil
+}
+
+// spillGrew accounts for a temporary file of an open handle growing by
+// delta bytes, or shrinking for a negative delta. Growth beyond
+// Options.QuotaBytes fails with ENOSPC, so a large upload can't fill the
+// disk before it is closed.
+func (hc *HotCache) spillGrew(delta int64) error {
+	quota := hc.fs.Options.QuotaBytes
+	if delta > 0 && quota > 0 && hc.backlogSize.Load()+hc.spilled.Load()+delta > quota {
+		return hc.reject()
+	}
+	hc.spilled.Add(delta)
+	return nil
+}
+
+// reject counts a write refused for lack of quota and wakes the garbage
+// collector to make room
+func (hc *HotCache) reject() error {
+	hc.rejected.Add(1)
+	select {
+	case hc.wake <- struct{}{}:
+	default: // A collection was asked for already
+	}
+	return syscall.ENOSPC
+}
+
+// checkHighWater warns when usage crosses the high-water mark of a quota,
+// once until it drops below again
+func (hc *HotCache) checkHighWater() {
+	opts := hc.fs.Options
+	above := func(used, quota int64) bool {
+		return quota > 0 && float64(used) >= opts.QuotaHighWater*float64(quota)
+	}
+	files, bytes := hc.backlog.Load(), hc.backlogSize.Load()
+	if !above(files, int64(opts.QuotaFiles)) && !above(bytes, opts.QuotaBytes) {
+		hc.highWater.Store(false)
+		return
+	}
+	if !hc.highWater.Swap(true) {
+		fmt.Printf("Warning: hot cache holds %d files and %d bytes, above %.0f%% of its quota (%d files, %d bytes); archiving is falling behind\n",
+			files, bytes, opts.QuotaHighWater*100, opts.QuotaFiles, opts.QuotaBytes)
+	}
+}
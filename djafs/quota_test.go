@@ -0,0 +1,128 @@
+package djafs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+func TestQuota_FlushReturnsENOSPC(t *testing.T) {
+	storage := t.TempDir()
+	opts := DefaultOptions()
+	opts.GCInterval = time.Hour
+	opts.PackFiles, opts.PackBytes = 0, 0
+	opts.QuotaFiles = 2
+	opts.QuotaBytes = 10
+	fsys := NewFSWithOptions(storage, opts)
+	defer fsys.Stop()
+	hc := fsys.HotCache
+
+	// Packing keeps failing
+	os.WriteFile(filepath.Join(storage, util.WorkDir), nil, 0o644)
+
+	if err := hc.WriteFile("a.json", []byte("12345")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := hc.WriteFile("b.json", []byte("123")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// Too many bytes
+	h := openHandle(t, fsys, "/live/a.json", fuse.OpenWriteOnly|fuse.OpenAppend)
+	writeHandle(t, h, 0, "6789")
+	if err := h.Flush(t.Context(), &fuse.FlushRequest{}); fuse.ToErrno(err) != fuse.Errno(syscall.ENOSPC) {
+		t.Errorf("Expected ENOSPC from Flush, got %v", err)
+	}
+	h.Release(t.Context(), &fuse.ReleaseRequest{})
+	if got := hotContent(t, fsys, "a.json"); got != "12345" {
+		t.Errorf("A refused write should leave the old content, got %q", got)
+	}
+
+	// Too many files
+	if err := hc.WriteFile("c.json", []byte("1")); fuse.ToErrno(err) != fuse.Errno(syscall.ENOSPC) {
+		t.Errorf("Expected ENOSPC for a third file, got %v", err)
+	}
+
+	// Shrinking a file always fits
+	if err := hc.WriteFile("a.json", []byte("1")); err != nil {
+		t.Errorf("Shrinking a file failed: %v", err)
+	}
+
+	stats := fsys.Stats().HotCache
+	if stats.Files != 2 || stats.Bytes != 4 || stats.Rejected != 2 || stats.QuotaFiles != 2 || stats.QuotaBytes != 10 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestQuota_SpilledWritesCount(t *testing.T) {
+	opts := DefaultOptions()
+	opts.GCInterval = time.Hour
+	opts.PackFiles, opts.PackBytes = 0, 0
+	opts.WriteBufferBytes = 4
+	opts.QuotaBytes = 20
+	fsys := NewFSWithOptions(t.TempDir(), opts)
+	defer fsys.Stop()
+	hc := fsys.HotCache
+
+	if err := hc.WriteFile("a.json", []byte("12345")); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	// The write fails before the temporary file outgrows the quota
+	h := openHandle(t, fsys, "/live/a.json", fuse.OpenWriteOnly|fuse.OpenAppend)
+	writeHandle(t, h, 0, "6789")
+	req := &fuse.WriteRequest{Data: []byte(strings.Repeat("x", 10))}
+	if err := h.Write(t.Context(), req, &fuse.WriteResponse{}); fuse.ToErrno(err) != fuse.Errno(syscall.ENOSPC) {
+		t.Errorf("Expected ENOSPC from Write, got %v", err)
+	}
+	if stats := fsys.Stats().HotCache; stats.Spilled != 9 || stats.Rejected != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// What was written so far fits once it replaces the old copy
+	closeHandle(t, h)
+	if got := hotContent(t, fsys, "a.json"); got != "123456789" {
+		t.Errorf("Expected the writes before the refused one, got %q", got)
+	}
+	if stats := fsys.Stats().HotCache; stats.Spilled != 0 || stats.Bytes != 9 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestQuota_HighWaterWarning(t *testing.T) {
+	opts := DefaultOptions()
+	opts.GCInterval = time.Hour
+	opts.PackFiles, opts.PackBytes = 0, 0
+	opts.QuotaFiles = 10
+	opts.QuotaHighWater = 0.5
+	fsys := NewFSWithOptions(t.TempDir(), opts)
+	defer fsys.Stop()
+	hc := fsys.HotCache
+
+	var out strings.Builder
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		if hc.highWater.Load() {
+			out.WriteString(name)
+		}
+		hc.WriteFile(name+".json", []byte("{}"))
+	}
+	// Crossed with the fifth file
+	if !hc.highWater.Load() || out.String() != "f" {
+		t.Errorf("Expected the high-water mark crossed at the fifth file, got %q", out.String())
+	}
+
+	// Packing brings usage back below it
+	hc.processFiles()
+	if hc.highWater.Load() {
+		t.Error("Expected the high-water mark to reset once the hot cache drained")
+	}
+	if stats := fsys.Stats().HotCache; stats.Files != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected an empty hot cache, got %+v", stats)
+	}
+}
@@ -0,0 +1,147 @@
+package djafs
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// readWindowSize is how many of the most recently returned bytes a deflate
+// member reader keeps, so that small backward seeks (out-of-order kernel
+// readahead, re-reads of the last page) don't restart decompression.
+const readWindowSize = 128 * 1024
+
+// memberReader serves ranged reads of a single archive member without
+// decompressing it into memory.
+//
+// Stored members are read directly from the archive file. Deflated members
+// can't seek, so one decompressor is kept open: reads ahead of it discard
+// output until the requested offset, reads within the window of recently
+// returned bytes are served from it, and anything further back restarts
+// decompression from the start of the member.
+type memberReader struct {
+	file    *zip.File
+	archive *pooledArchive // held until Close
+	pool    *archivePool
+	rc      io.ReadCloser // open decompressor for deflated members
+	pos     int64         // offset of the next byte rc returns
+	window  []byte        // bytes immediately before pos
+	mu      sync.Mutex
+}
+
+// openMember opens target inside the archive at archivePath for ranged reads
+func openMember(pool *archivePool, archivePath, target string) (*memberReader, error) {
+	archive, err := pool.Acquire(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, ok := archive.File(target)
+	if !ok {
+		pool.Release(archive)
+		return nil, fmt.Errorf("file %s not found in archive %s", target, archivePath)
+	}
+	return &memberReader{file: f, archive: archive, pool: pool}, nil
+}
+
+// Size returns the uncompressed size of the member
+func (m *memberReader) Size() int64 {
+	return int64(m.file.UncompressedSize64)
+}
+
+// ReadAt implements io.ReaderAt over the uncompressed member content
+func (m *memberReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= m.Size() {
+		return 0, io.EOF
+	}
+
+	if m.file.Method == zip.Store {
+		dataOffset, err := m.file.DataOffset()
+		if err != nil {
+			return 0, err
+		}
+		return io.NewSectionReader(m.archive.raw, dataOffset, m.Size()).ReadAt(p, off)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	// Serve the start of the request from the window when possible
+	if windowStart := m.pos - int64(len(m.window)); off >= windowStart && off < m.pos {
+		n = copy(p, m.window[off-windowStart:])
+		off += int64(n)
+		if n == len(p) {
+			return n, nil
+		}
+	}
+
+	if m.rc == nil || off < m.pos {
+		if err := m.restart(); err != nil {
+			return n, err
+		}
+	}
+
+	// Skip forward to the requested offset
+	if skip := off - m.pos; skip > 0 {
+		skipped, err := io.CopyN(io.Discard, m.rc, skip)
+		m.pos += skipped
+		m.window = m.window[:0]
+		if err != nil {
+			return n, err
+		}
+	}
+
+	read, err := io.ReadFull(m.rc, p[n:])
+	m.remember(p[n : n+read])
+	n += read
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// restart reopens the decompressor at the start of the member
+func (m *memberReader) restart() error {
+	if m.rc != nil {
+		m.rc.Close()
+	}
+	rc, err := m.file.Open()
+	if err != nil {
+		m.rc = nil
+		return fmt.Errorf("failed to open file %s in archive: %w", m.file.Name, err)
+	}
+	m.rc = rc
+	m.pos = 0
+	m.window = m.window[:0]
+	return nil
+}
+
+// remember advances pos past b and keeps the tail in the window
+func (m *memberReader) remember(b []byte) {
+	m.pos += int64(len(b))
+	if len(b) >= readWindowSize {
+		m.window = append(m.window[:0], b[len(b)-readWindowSize:]...)
+		return
+	}
+	if drop := len(m.window) + len(b) - readWindowSize; drop > 0 {
+		m.window = append(m.window[:0], m.window[drop:]...)
+	}
+	m.window = append(m.window, b...)
+}
+
+// Close releases the decompressor and returns the archive to the pool
+func (m *memberReader) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rc != nil {
+		m.rc.Close()
+		m.rc = nil
+	}
+	if m.archive != nil {
+		m.pool.Release(m.archive)
+		m.archive = nil
+	}
+	return nil
+}
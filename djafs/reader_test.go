@@ -0,0 +1,126 @@
+package djafs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// writeTestArchive creates a zip holding members compressed with method
+func writeTestArchive(t *testing.T, path string, members map[string][]byte, method uint16) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer f.Close()
+	w := zip.NewWriter(f)
+	for name, content := range members {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: name, Method: method})
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		fw.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to finish archive: %v", err)
+	}
+}
+
+// testContent returns size bytes of position-dependent JSON-ish data
+func testContent(size int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < size; i++ {
+		fmt.Fprintf(&buf, `{"reading":%d,"value":%d}`+"\n", i, i*7)
+	}
+	return buf.Bytes()[:size]
+}
+
+func TestMemberReader_RangedReads(t *testing.T) {
+	content := testContent(3 * readWindowSize)
+
+	for _, method := range []uint16{zip.Deflate, zip.Store} {
+		t.Run(fmt.Sprintf("method-%d", method), func(t *testing.T) {
+			archivePath := filepath.Join(t.TempDir(), "test.djfz")
+			writeTestArchive(t, archivePath, map[string][]byte{"member": content}, method)
+
+			r, err := openMember(nil, archivePath, "member")
+			if err != nil {
+				t.Fatalf("openMember failed: %v", err)
+			}
+			defer r.Close()
+
+			// Forward, tail, backward within the window, and backward past it
+			for _, rng := range []struct{ off, size int }{
+				{0, 4096},
+				{len(content) - 100, 100},
+				{len(content) - 5000, 4096},
+				{10, 50},
+				{readWindowSize, readWindowSize + 10},
+			} {
+				buf := make([]byte, rng.size)
+				n, err := r.ReadAt(buf, int64(rng.off))
+				if err != nil {
+					t.Fatalf("ReadAt(%d, %d) failed: %v", rng.off, rng.size, err)
+				}
+				if !bytes.Equal(buf[:n], content[rng.off:rng.off+rng.size]) {
+					t.Fatalf("ReadAt(%d, %d) returned wrong data", rng.off, rng.size)
+				}
+			}
+
+			// Reads past the end are short
+			buf := make([]byte, 200)
+			n, _ := r.ReadAt(buf, int64(len(content)-50))
+			if n != 50 {
+				t.Errorf("Expected short read of 50 bytes, got %d", n)
+			}
+			if n, _ := r.ReadAt(buf, int64(len(content))); n != 0 {
+				t.Errorf("Expected empty read at EOF, got %d bytes", n)
+			}
+		})
+	}
+}
+
+func TestFileRead_StreamsFromArchive(t *testing.T) {
+	storage := t.TempDir()
+	content := testContent(512 * 1024)
+	target := util.HashPathFromHash(fmt.Sprintf("%064x", 42))
+
+	archivePath := filepath.Join(storage, "1-00000.djfz")
+	writeTestArchive(t, archivePath, map[string][]byte{target: content}, zip.Deflate)
+
+	targets, _ := util.LoadTargetIndex(storage)
+	targets.Set(target, archivePath)
+	f := &File{
+		fs:    &FS{StoragePath: storage, Targets: targets},
+		entry: &util.LookupEntry{Name: "big.json", Target: target, FileSize: int64(len(content))},
+	}
+
+	ctx := context.Background()
+	h := f.newHandle(fuse.OpenReadOnly)
+	req := &fuse.ReadRequest{Offset: int64(len(content) - 4096), Size: 4096}
+	resp := &fuse.ReadResponse{Data: make([]byte, 0, req.Size)}
+	if err := h.Read(ctx, req, resp); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(resp.Data, content[len(content)-4096:]) {
+		t.Error("Read returned wrong tail data")
+	}
+	if h.data != nil {
+		t.Error("Reading an archived file should not buffer its content")
+	}
+
+	if err := h.Release(ctx, &fuse.ReleaseRequest{}); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if h.reader != nil {
+		t.Error("Release should close the member reader")
+	}
+}
@@ -0,0 +1,143 @@
+package djafs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// RecoveryLog is the name of the log of startup recovery in hot_cache
+const RecoveryLog = "recovery.log"
+
+// recoverStaging finishes or rolls back every file a crashed collection
+// left in staging, and appends what it did to the recovery log. Every step
+// can be repeated, so a crash during recovery is recovered from on the
+// next start.
+func (hc *HotCache) recoverStaging() {
+	var staged []string
+	filepath.Walk(hc.StagingDir, func(p string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			staged = append(staged, p)
+		}
+		return nil
+	})
+	if len(staged) == 0 {
+		return
+	}
+
+	logFile, err := os.OpenFile(filepath.Join(filepath.Dir(hc.StagingDir), RecoveryLog), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		fmt.Printf("Error opening recovery log: %v\n", err)
+	} else {
+		defer logFile.Close()
+	}
+
+	report := func(action, relPath string, err error) {
+		line := fmt.Sprintf("%s %s %s", time.Now().UTC().Format(time.RFC3339), action, relPath)
+		if err != nil {
+			line += ": " + err.Error()
+		}
+		fmt.Printf("Recovery: %s\n", line)
+		if logFile != nil {
+			fmt.Fprintln(logFile, line)
+		}
+	}
+
+	var pending []*stagedFile
+	for _, stagingPath := range staged {
+		rel, err := filepath.Rel(hc.StagingDir, stagingPath)
+		if err != nil {
+			continue
+		}
+		relPath := filepath.ToSlash(rel)
+
+		recorded, err := hc.fs.recordedEntries(relPath)
+		if err != nil {
+			report(recoveryKept, relPath, err)
+			continue
+		}
+		f, action, err := hc.recoverFile(stagingPath, relPath, recorded)
+		if f != nil {
+			pending = append(pending, f)
+			continue
+		}
+		report(action, relPath, err)
+	}
+
+	// Everything left goes through the pipeline at once, so the archives
+	// are packed once rather than for every file
+	hc.gcMu.Lock()
+	hc.archive(pending)
+	hc.gcMu.Unlock()
+	for _, f := range pending {
+		if f.err != nil {
+			action, err := hc.rollBack(f.stagingPath, f.relPath, f.err)
+			report(action, f.relPath, err)
+			continue
+		}
+		report(recoveryFinished, f.relPath, nil)
+	}
+}
+
+// Outcomes of recovering a staged file
+const (
+	recoveryCleaned    = "cleaned"     // Entry was recorded, staging copy removed
+	recoveryFinished   = "finished"    // Processed through the pipeline
+	recoveryRolledBack = "rolled-back" // Moved back to incoming
+	recoveryKept       = "kept"        // Left in staging for the next start
+)
+
+// recoverFile cleans up a staged file whose entry is already recorded, or
+// returns it to go through the pipeline again, dropping a work directory
+// copy an interrupted copy left behind. Packing merges with the archive,
+// so content packed before the crash isn't duplicated. recorded holds the
+// entries of relPath already in lookup tables.
+func (hc *HotCache) recoverFile(stagingPath, relPath string, recorded []util.LookupEntry) (*stagedFile, string, error) {
+	info, err := os.Stat(stagingPath)
+	if err != nil {
+		return nil, recoveryKept, err
+	}
+	hash, err := util.GetFileHash(stagingPath)
+	if err != nil {
+		action, err := hc.rollBack(stagingPath, relPath, err)
+		return nil, action, err
+	}
+	target := util.HashPathFromHash(hash)
+
+	owner, hasOwner := hc.Owner(relPath)
+	for _, entry := range recorded {
+		if entry.Target == target && entry.Modified.Equal(info.ModTime()) {
+			hc.finishFile(stagingPath, relPath, owner, hasOwner)
+			return nil, recoveryCleaned, nil
+		}
+	}
+
+	// An interrupted copy would be taken as a finished one
+	workPath := hc.workPath(target)
+	if workHash, err := util.GetFileHash(workPath); err == nil && workHash != hash {
+		os.Remove(workPath)
+	}
+
+	return &stagedFile{stagingPath: stagingPath, relPath: relPath}, "", nil
+}
+
+// rollBack returns a staged file that failed to process to incoming, where
+// the garbage collector retries it. A newer copy in incoming supersedes it
+// there, so it is kept in staging instead.
+func (hc *HotCache) rollBack(stagingPath, relPath string, cause error) (string, error) {
+	incomingPath := filepath.Join(hc.IncomingDir, relPath)
+	if _, err := os.Stat(incomingPath); err == nil {
+		return recoveryKept, cause
+	}
+	if err := os.MkdirAll(filepath.Dir(incomingPath), 0755); err != nil {
+		return recoveryKept, err
+	}
+	if err := os.Rename(stagingPath, incomingPath); err != nil {
+		return recoveryKept, err
+	}
+	hc.cleanupEmptyDirs(filepath.Dir(stagingPath))
+	return recoveryRolledBack, cause
+}
@@ -0,0 +1,112 @@
+package djafs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// stageFile writes content to the staging copy of logical path p, as the
+// garbage collector leaves it after moving it out of incoming
+func stageFile(t *testing.T, hc *HotCache, p, content string) string {
+	t.Helper()
+	stagingPath := filepath.Join(hc.StagingDir, p)
+	os.MkdirAll(filepath.Dir(stagingPath), 0o755)
+	if err := os.WriteFile(stagingPath, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to stage %s: %v", p, err)
+	}
+	return stagingPath
+}
+
+// recoveryLog returns the recovery log of the hot cache
+func recoveryLog(t *testing.T, storage string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(storage, "hot_cache", RecoveryLog))
+	if err != nil {
+		t.Fatalf("Reading the recovery log failed: %v", err)
+	}
+	return string(data)
+}
+
+func TestRecovery_FinishesStagedFiles(t *testing.T) {
+	storage := t.TempDir()
+	fsys := NewFS(storage)
+	hc := fsys.HotCache
+
+	// Crashed after the lookup table was updated
+	recorded := stageFile(t, hc, "sensors/recorded.json", `{"v":1}`)
+	entry, err := hc.ingest(recorded, "sensors/recorded.json")
+	if err != nil {
+		t.Fatalf("ingest failed: %v", err)
+	}
+	if err := hc.updateLookupTable(entry); err != nil {
+		t.Fatalf("updateLookupTable failed: %v", err)
+	}
+
+	// Crashed while copying into the work directory
+	copied := stageFile(t, hc, "sensors/copied.json", `{"v":2}`)
+	hash, _ := util.GetFileHash(copied)
+	target := util.HashPathFromHash(hash)
+	workPath := hc.workPath(target)
+	os.MkdirAll(filepath.Dir(workPath), 0o755)
+	os.WriteFile(workPath, []byte(`{"v`), 0o644)
+	fsys.Stop()
+
+	// Recovery runs on every start and must be repeatable
+	for range 2 {
+		fsys = NewFS(storage)
+		fsys.Stop()
+	}
+
+	if entries, _ := os.ReadDir(fsys.HotCache.StagingDir); len(entries) != 0 {
+		t.Errorf("Expected an empty staging directory, found %d entries", len(entries))
+	}
+	var names []string
+	fsys.walkLookupEntries(func(entry util.LookupEntry) {
+		names = append(names, entry.Name)
+	})
+	if len(names) != 2 {
+		t.Errorf("Expected each file recorded once, got %v", names)
+	}
+	if data, err := fsys.loadFileContent(&util.LookupEntry{Target: target}); string(data) != `{"v":2}` {
+		t.Errorf("Expected the partial work copy to be replaced, got %q, %v", data, err)
+	}
+
+	log := recoveryLog(t, storage)
+	if !strings.Contains(log, recoveryCleaned+" sensors/recorded.json") || !strings.Contains(log, recoveryFinished+" sensors/copied.json") {
+		t.Errorf("Unexpected recovery log:\n%s", log)
+	}
+}
+
+func TestRecovery_RollsBackFailures(t *testing.T) {
+	storage := t.TempDir()
+	fsys := NewFS(storage)
+	stageFile(t, fsys.HotCache, "a.json", "staged")
+	stageFile(t, fsys.HotCache, "b.json", "staged")
+	fsys.HotCache.WriteFile("b.json", []byte("newer"))
+	fsys.Stop()
+
+	// Nothing can be copied into the work directory
+	os.WriteFile(filepath.Join(storage, util.WorkDir), nil, 0o644)
+
+	fsys = NewFS(storage)
+	fsys.Stop()
+
+	if got := hotContent(t, fsys, "a.json"); got != "staged" {
+		t.Errorf("Expected a.json back in incoming, got %q", got)
+	}
+	if got := hotContent(t, fsys, "b.json"); got != "newer" {
+		t.Errorf("Expected the newer b.json to stay in incoming, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(fsys.HotCache.StagingDir, "b.json")); err != nil {
+		t.Errorf("Expected the older b.json kept in staging: %v", err)
+	}
+
+	log := recoveryLog(t, storage)
+	if !strings.Contains(log, recoveryRolledBack+" a.json") || !strings.Contains(log, recoveryKept+" b.json") {
+		t.Errorf("Unexpected recovery log:\n%s", log)
+	}
+}
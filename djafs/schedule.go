@@ -0,0 +1,136 @@
+package djafs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxQuietWindow bounds how long a quiet window may stay open
+const maxQuietWindow = 7 * 24 * time.Hour
+
+// QuietWindow is a recurring period in which the garbage collector defers
+// packing. It is written as a cron schedule of when the window opens
+// (minute, hour, day of month, month and day of week, in local time)
+// followed by how long it stays open: "0 1 * * * 3h" is quiet from 01:00
+// to 04:00 every night, "30 22 * * 1-5 90m" from 22:30 to 00:00 on
+// weeknights.
+type QuietWindow struct {
+	spec     string
+	minute   uint64 // Bit n set if the window opens at minute n
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	anyDay   bool // Day of month or day of week starts with "*", both must match
+	Duration time.Duration
+}
+
+// ParseQuietWindow parses a quiet window such as "0 1 * * * 3h"
+func ParseQuietWindow(s string) (QuietWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 6 {
+		return QuietWindow{}, fmt.Errorf("quiet window %q: expected minute, hour, day of month, month, day of week and duration", s)
+	}
+
+	w := QuietWindow{spec: strings.Join(fields, " ")}
+	for i, f := range []struct {
+		bits   *uint64
+		lo, hi int
+	}{
+		{&w.minute, 0, 59},
+		{&w.hour, 0, 23},
+		{&w.dom, 1, 31},
+		{&w.month, 1, 12},
+		{&w.dow, 0, 7},
+	} {
+		bits, err := parseCronField(fields[i], f.lo, f.hi)
+		if err != nil {
+			return QuietWindow{}, fmt.Errorf("quiet window %q: %w", s, err)
+		}
+		*f.bits = bits
+	}
+
+	// Sunday is both 0 and 7
+	if w.dow&(1<<7) != 0 {
+		w.dow |= 1
+	}
+	w.anyDay = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+
+	d, err := time.ParseDuration(fields[5])
+	if err != nil || d < time.Minute || d > maxQuietWindow {
+		return QuietWindow{}, fmt.Errorf("quiet window %q: duration must be between 1m and %v", s, maxQuietWindow)
+	}
+	w.Duration = d
+	return w, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (a-b),
+// "*" and steps (*/n, a-b/n, a/n) between lo and hi into a bitset
+func parseCronField(f string, lo, hi int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if base, s, ok := strings.Cut(part, "/"); ok {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part, step = base, n
+		}
+
+		first, last := lo, hi
+		if part != "*" {
+			a, b, isRange := strings.Cut(part, "-")
+			var err error
+			if first, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			switch {
+			case isRange:
+				if last, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid range %q", part)
+				}
+			case step == 1:
+				last = first
+			}
+		}
+		if first < lo || last > hi || first > last {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, lo, hi)
+		}
+		for v := first; v <= last; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// opensAt reports whether the window opens at the minute of t
+func (w QuietWindow) opensAt(t time.Time) bool {
+	has := func(bits uint64, v int) bool { return bits&(1<<v) != 0 }
+	if !has(w.minute, t.Minute()) || !has(w.hour, t.Hour()) || !has(w.month, int(t.Month())) {
+		return false
+	}
+	dom, dow := has(w.dom, t.Day()), has(w.dow, int(t.Weekday()))
+	if w.anyDay {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Active reports whether t falls into the window
+func (w QuietWindow) Active(t time.Time) bool {
+	t = t.Truncate(time.Minute)
+	for open := t; t.Sub(open) < w.Duration; open = open.Add(-time.Minute) {
+		if w.opensAt(open) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the window as it was parsed
+func (w QuietWindow) String() string {
+	return w.spec
+}
@@ -0,0 +1,75 @@
+package djafs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuietWindow(t *testing.T) {
+	for _, spec := range []string{
+		"0 1 * * *",          // No duration
+		"0 24 * * * 1h",      // Hour out of range
+		"0 1 * * * 0s",       // Never open
+		"0 1 * * * 200h",     // Longer than a week
+		"0 5-1 * * * 1h",     // Backwards range
+		"0 */0 * * * 1h",     // Zero step
+		"0 1 * * mon 1h",     // Names aren't supported
+		"0 1 * * * 1h extra", // Too many fields
+	} {
+		if _, err := ParseQuietWindow(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+
+	w, err := ParseQuietWindow("30 22 * * 1-5 3h")
+	if err != nil {
+		t.Fatalf("ParseQuietWindow failed: %v", err)
+	}
+	if w.String() != "30 22 * * 1-5 3h" || w.Duration != 3*time.Hour {
+		t.Errorf("Unexpected window %v lasting %v", w, w.Duration)
+	}
+}
+
+func TestQuietWindow_Active(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		ts, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		spec string
+		at   string
+		want bool
+	}{
+		{"0 1 * * * 3h", "2024-03-05 00:59", false},
+		{"0 1 * * * 3h", "2024-03-05 01:00", true},
+		{"0 1 * * * 3h", "2024-03-05 03:59", true},
+		{"0 1 * * * 3h", "2024-03-05 04:00", false},
+		// Weeknights only, spanning midnight: 2024-03-08 is a Friday
+		{"30 22 * * 1-5 3h", "2024-03-09 00:30", true},
+		{"30 22 * * 1-5 3h", "2024-03-09 23:00", false},
+		// Sunday as 7
+		{"0 0 * * 7 24h", "2024-03-10 12:00", true},
+		// Steps and lists
+		{"*/15 * * * * 5m", "2024-03-05 10:47", true},
+		{"*/15 * * * * 5m", "2024-03-05 10:50", false},
+		{"0 2,14 * * * 1h", "2024-03-05 14:30", true},
+		// Day of month or day of week when both are restricted
+		{"0 0 1 * 0 24h", "2024-03-01 08:00", true},
+		{"0 0 1 * 0 24h", "2024-03-10 08:00", true},
+		{"0 0 1 * 0 24h", "2024-03-05 08:00", false},
+	}
+	for _, tt := range tests {
+		w, err := ParseQuietWindow(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseQuietWindow(%q) failed: %v", tt.spec, err)
+		}
+		if got := w.Active(at(tt.at)); got != tt.want {
+			t.Errorf("%q at %s: Active = %v, want %v", tt.spec, tt.at, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package djafs
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+)
+
+// Levels of the /snapshots hierarchy, in UTC. Hours and minutes start with
+// "@" so they can't be confused with files at the top of a snapshot.
+const (
+	snapshotYear = iota
+	snapshotMonth
+	snapshotDay
+	snapshotHour
+	snapshotMinute
+	snapshotInstant // latest or @RFC3339
+)
+
+// snapshotPath is a path below /snapshots split into the instant it selects
+// and the path inside the snapshot. Days, hours and minutes select their
+// end: /snapshots/2024/03/05/@14 shows the storage at 14:59:59.999999999.
+type snapshotPath struct {
+	level  int
+	start  time.Time  // Start of the selected year, month, day, hour or minute
+	at     *time.Time // Instant shown, nil for latest
+	inside string     // Logical path inside the snapshot, "" at its root
+}
+
+// parseSnapshotPath parses a path below /snapshots, such as
+// "/snapshots/2024/03/05/@14/sensors". It fails for invalid dates and for
+// paths inside a year or month, which don't select an instant.
+func parseSnapshotPath(p string) (snapshotPath, bool) {
+	parts := strings.Split(strings.TrimPrefix(p, "/snapshots/"), "/")
+	var sp snapshotPath
+
+	switch first := parts[0]; {
+	case first == "latest":
+		sp.level = snapshotInstant
+		parts = parts[1:]
+
+	case strings.HasPrefix(first, "@"):
+		at, err := time.Parse(time.RFC3339, first[1:])
+		if err != nil {
+			return sp, false
+		}
+		sp.level = snapshotInstant
+		sp.start = at
+		sp.at = &at
+		parts = parts[1:]
+
+	default:
+		year, err := time.Parse("2006", first)
+		if err != nil {
+			return sp, false
+		}
+		sp.level = snapshotYear
+		sp.start = year
+		parts = parts[1:]
+
+		if len(parts) > 0 {
+			month, err := time.Parse("2006/01", first+"/"+parts[0])
+			if err != nil {
+				return sp, false
+			}
+			sp.level = snapshotMonth
+			sp.start = month
+			parts = parts[1:]
+		}
+		if len(parts) > 0 {
+			day, err := time.Parse("2006/01/02", sp.start.Format("2006/01")+"/"+parts[0])
+			if err != nil {
+				return sp, false
+			}
+			sp.level = snapshotDay
+			sp.start = day
+			parts = parts[1:]
+		}
+		if sp.level < snapshotDay {
+			// Years and months only hold the next level
+			return sp, len(parts) == 0
+		}
+
+		period := 24 * time.Hour
+		if len(parts) > 0 {
+			if hour, ok := parseSnapshotUnit(parts[0], 24); ok {
+				sp.level = snapshotHour
+				sp.start = sp.start.Add(time.Duration(hour) * time.Hour)
+				period = time.Hour
+				parts = parts[1:]
+			}
+		}
+		if sp.level == snapshotHour && len(parts) > 0 {
+			if minute, ok := parseSnapshotUnit(parts[0], 60); ok {
+				sp.level = snapshotMinute
+				sp.start = sp.start.Add(time.Duration(minute) * time.Minute)
+				period = time.Minute
+				parts = parts[1:]
+			}
+		}
+
+		// Show everything up to the last instant of the period
+		at := sp.start.Add(period - time.Nanosecond)
+		sp.at = &at
+	}
+
+	sp.inside = strings.Join(parts, "/")
+	return sp, true
+}
+
+// parseSnapshotUnit parses an hour or minute level such as "@07", which must
+// be below limit
+func parseSnapshotUnit(name string, limit int) (int, bool) {
+	if len(name) != 3 || name[0] != '@' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(name[1:])
+	return n, err == nil && n >= 0 && n < limit
+}
+
+// resolveSnapshotPath resolves paths within the /snapshots directory
+func (d *Dir) resolveSnapshotPath(name string) (fs.Node, error) {
+	p := d.path + "/" + name
+	sp, ok := parseSnapshotPath(p)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	snapshotDir := &Dir{
+		fs:           d.fs,
+		path:         p,
+		isSnapshot:   true,
+		snapshotTime: sp.at,
+	}
+	if sp.inside == "" {
+		return snapshotDir, nil
+	}
+
+	// Try to find the file at the snapshot time
+	entry, err := d.fs.findFileEntryAtTime("/"+sp.inside, sp.at)
+	if err == nil {
+		entry.Name = sp.inside // Lookup tables hold names relative to their boundary
+		return &File{
+			fs:       d.fs,
+			entry:    entry,
+			snapshot: true,
+		}, nil
+	}
+
+	// Check if it's a directory by looking for files with this prefix at snapshot time
+	if d.fs.hasFilesWithPrefixAtTime(sp.inside+"/", sp.at) {
+		return snapshotDir, nil
+	}
+
+	return nil, syscall.ENOENT
+}
+
+// snapshotDirents lists a directory below /snapshots: the next level of the
+// hierarchy, then the files of the snapshot once it selects an instant
+func (d *Dir) snapshotDirents() ([]fuse.Dirent, error) {
+	sp, ok := parseSnapshotPath(d.path)
+	if !ok {
+		return nil, syscall.ENOENT
+	}
+
+	var levels []string
+	switch {
+	case sp.level == snapshotYear:
+		levels = d.fs.getAvailableSnapshotMonths(sp.start.Format("2006"))
+	case sp.level == snapshotMonth:
+		levels = d.fs.getAvailableSnapshotDays(sp.start.Format("2006"), sp.start.Format("01"))
+	case sp.level == snapshotDay && sp.inside == "":
+		levels = d.fs.getAvailableSnapshotUnits(sp.start, 24*time.Hour, "@15")
+	case sp.level == snapshotHour && sp.inside == "":
+		levels = d.fs.getAvailableSnapshotUnits(sp.start, time.Hour, "@04")
+	}
+
+	var dirents []fuse.Dirent
+	for _, level := range levels {
+		dirents = append(dirents, fuse.Dirent{
+			Inode: d.fs.Inodes.Get(d.path + "/" + level),
+			Name:  level,
+			Type:  fuse.DT_Dir,
+		})
+	}
+	if sp.level < snapshotDay {
+		return dirents, nil
+	}
+
+	prefix := ""
+	if sp.inside != "" {
+		prefix = sp.inside + "/"
+	}
+	entries, err := d.fs.getEntriesWithPrefixAtTime(prefix, sp.at)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := make(map[string]bool)
+	for _, entry := range entries {
+		relativePath := strings.TrimPrefix(entry.Name, prefix)
+		parts := strings.Split(relativePath, "/")
+
+		if len(parts) == 1 && !entry.IsDir() {
+			// File directly in this directory
+			dirents = append(dirents, fuse.Dirent{
+				Inode: entry.Inode,
+				Name:  parts[0],
+				Type:  fuse.DT_File,
+			})
+		} else {
+			// Subdirectory, possibly a recorded empty one
+			dirs[parts[0]] = true
+		}
+	}
+
+	// Add directories
+	for dir := range dirs {
+		dirents = append(dirents, fuse.Dirent{
+			Inode: d.fs.Inodes.Get(d.path + "/" + dir),
+			Name:  dir,
+			Type:  fuse.DT_Dir,
+		})
+	}
+
+	return dirents, nil
+}
+
+// getAvailableSnapshotUnits returns the distinct times, formatted with layout
+// in UTC, at which something changed within span from start
+func (fs *FS) getAvailableSnapshotUnits(start time.Time, span time.Duration, layout string) []string {
+	unitSet := make(map[string]bool)
+	end := start.Add(span)
+
+	err := filepath.Walk(fs.StoragePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+
+		if !strings.HasSuffix(path, "lookups.djfl") {
+			return nil
+		}
+
+		lookupTable, err := fs.loadLookupTable(path)
+		if err != nil {
+			return nil
+		}
+
+		for entry := range lookupTable.Iterate {
+			if !entry.Modified.Before(start) && entry.Modified.Before(end) {
+				unitSet[entry.Modified.UTC().Format(layout)] = true
+			}
+		}
+
+		return nil
+	})
+
+	var units []string
+	if err == nil {
+		for unit := range unitSet {
+			units = append(units, unit)
+		}
+	}
+	slices.Sort(units)
+
+	return units
+}
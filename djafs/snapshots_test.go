@@ -0,0 +1,193 @@
+package djafs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse/fs"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// lookupPath resolves a mount path from the root one name at a time
+func lookupPath(fsys *FS, p string) (fs.Node, error) {
+	var node fs.Node = &Dir{fs: fsys, path: "/"}
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		dir, ok := node.(*Dir)
+		if !ok {
+			return nil, syscall.ENOTDIR
+		}
+		next, err := dir.Lookup(context.Background(), name)
+		if err != nil {
+			return nil, err
+		}
+		node = next
+	}
+	return node, nil
+}
+
+func TestParseSnapshotPath(t *testing.T) {
+	at := func(s string) time.Time {
+		v, _ := time.Parse(time.RFC3339Nano, s)
+		return v
+	}
+
+	tests := []struct {
+		path   string
+		ok     bool
+		level  int
+		at     time.Time
+		inside string
+	}{
+		{"/snapshots/latest/a/b.json", true, snapshotInstant, time.Time{}, "a/b.json"},
+		{"/snapshots/@2024-03-05T14:30:00Z/a", true, snapshotInstant, at("2024-03-05T14:30:00Z"), "a"},
+		{"/snapshots/@2024-03-05T16:30:00+02:00", true, snapshotInstant, at("2024-03-05T14:30:00Z"), ""},
+		{"/snapshots/@yesterday", false, 0, time.Time{}, ""},
+		{"/snapshots/2024", true, snapshotYear, time.Time{}, ""},
+		{"/snapshots/2024/03", true, snapshotMonth, time.Time{}, ""},
+		{"/snapshots/2024/13", false, 0, time.Time{}, ""},
+		{"/snapshots/2024/03/x.json", false, 0, time.Time{}, ""},
+		{"/snapshots/2024/02/30", false, 0, time.Time{}, ""},
+		{"/snapshots/2024/03/05/a", true, snapshotDay, at("2024-03-05T23:59:59.999999999Z"), "a"},
+		{"/snapshots/2024/03/05/@14", true, snapshotHour, at("2024-03-05T14:59:59.999999999Z"), ""},
+		{"/snapshots/2024/03/05/@14/@30/a", true, snapshotMinute, at("2024-03-05T14:30:59.999999999Z"), "a"},
+		{"/snapshots/2024/03/05/@24", true, snapshotDay, at("2024-03-05T23:59:59.999999999Z"), "@24"},
+		{"/snapshots/2024/03/05/@30", true, snapshotDay, at("2024-03-05T23:59:59.999999999Z"), "@30"},
+	}
+
+	for _, tt := range tests {
+		sp, ok := parseSnapshotPath(tt.path)
+		if ok != tt.ok {
+			t.Errorf("parseSnapshotPath(%q) ok = %v, want %v", tt.path, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if sp.level != tt.level || sp.inside != tt.inside {
+			t.Errorf("parseSnapshotPath(%q) = level %d inside %q, want level %d inside %q",
+				tt.path, sp.level, sp.inside, tt.level, tt.inside)
+		}
+		if !tt.at.IsZero() && (sp.at == nil || !sp.at.Equal(tt.at)) {
+			t.Errorf("parseSnapshotPath(%q) at = %v, want %v", tt.path, sp.at, tt.at)
+		}
+	}
+}
+
+func TestSnapshots_ExactInstants(t *testing.T) {
+	storage := t.TempDir()
+	day := time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)
+	writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "loc1/a.json", Target: "1-00000-aaa", Modified: day.Add(14*time.Hour + 10*time.Minute)},
+		util.LookupEntry{Name: "loc1/a.json", Target: "1-00000-bbb", Modified: day.Add(14*time.Hour + 40*time.Minute)},
+		util.LookupEntry{Name: "loc1/b.json", Target: "1-00000-ccc", Modified: day.Add(16 * time.Hour)},
+		util.LookupEntry{Name: "loc1/a.json", Modified: day.Add(25 * time.Hour)},
+	)
+
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	targets := map[string]string{
+		"/snapshots/@2024-03-05T14:30:00Z/sensors/loc1/a.json": "1-00000-aaa",
+		"/snapshots/@2024-03-05T14:40:00Z/sensors/loc1/a.json": "1-00000-bbb",
+		"/snapshots/2024/03/05/@14/@20/sensors/loc1/a.json":    "1-00000-aaa",
+		"/snapshots/2024/03/05/@14/sensors/loc1/a.json":        "1-00000-bbb",
+		"/snapshots/2024/03/05/sensors/loc1/b.json":            "1-00000-ccc",
+		"/snapshots/latest/sensors/loc1/b.json":                "1-00000-ccc",
+	}
+	for p, target := range targets {
+		node, err := lookupPath(fsys, p)
+		if err != nil {
+			t.Errorf("Lookup %s failed: %v", p, err)
+			continue
+		}
+		if f, ok := node.(*File); !ok || f.entry.Target != target {
+			t.Errorf("Expected %s to resolve to %s, got %+v", p, target, node)
+		}
+	}
+
+	for _, p := range []string{
+		"/snapshots/@2024-03-05T14:00:00Z/sensors/loc1/a.json",
+		"/snapshots/2024/03/05/@14/sensors/loc1/b.json",
+		"/snapshots/2024/03/06/sensors/loc1/a.json",
+		"/snapshots/latest/sensors/loc1/a.json",
+		"/snapshots/@not-a-time",
+	} {
+		if _, err := lookupPath(fsys, p); !errors.Is(err, syscall.ENOENT) {
+			t.Errorf("Expected ENOENT for %s, got %v", p, err)
+		}
+	}
+
+	// Days list the hours that changed next to the files
+	names := func(p string) []string {
+		t.Helper()
+		node, err := lookupPath(fsys, p)
+		if err != nil {
+			t.Fatalf("Lookup %s failed: %v", p, err)
+		}
+		dirents, err := node.(*Dir).ReadDirAll(context.Background())
+		if err != nil {
+			t.Fatalf("ReadDirAll %s failed: %v", p, err)
+		}
+		var names []string
+		for _, d := range dirents {
+			names = append(names, d.Name)
+		}
+		return names
+	}
+	if got := strings.Join(names("/snapshots/2024/03/05"), ","); got != "@14,@16,sensors" {
+		t.Errorf("Unexpected day listing %q", got)
+	}
+	if got := strings.Join(names("/snapshots/2024/03/05/@14"), ","); got != "@10,@40,sensors" {
+		t.Errorf("Unexpected hour listing %q", got)
+	}
+	if got := strings.Join(names("/snapshots/2024/03/05/@14/@10/sensors/loc1"), ","); got != "a.json" {
+		t.Errorf("Unexpected minute listing %q", got)
+	}
+	if got := strings.Join(names("/snapshots/2024/03/06/sensors/loc1"), ","); got != "b.json" {
+		t.Errorf("Deleted file should be gone the next day, got %q", got)
+	}
+}
+
+func TestSnapshots_NonUTCOffsets(t *testing.T) {
+	storage := t.TempDir()
+	pst := time.FixedZone("PST", -8*60*60)
+	// 2025-01-01T04:00:00Z
+	writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "a.json", Target: "1-00000-aaa", Modified: time.Date(2024, 12, 31, 20, 0, 0, 0, pst)},
+	)
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+
+	ctx := context.Background()
+	listing := func(p string) string {
+		t.Helper()
+		node, err := lookupPath(fsys, p)
+		if err != nil {
+			t.Fatalf("Lookup %s failed: %v", p, err)
+		}
+		dirents, _ := node.(*Dir).ReadDirAll(ctx)
+		var names []string
+		for _, d := range dirents {
+			names = append(names, d.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	if got := listing("/snapshots"); !strings.Contains(got, "2025") || strings.Contains(got, "2024") {
+		t.Errorf("Expected the year in UTC, got %q", got)
+	}
+	if got := listing("/snapshots/2025"); got != "01" {
+		t.Errorf("Expected the month in UTC, got %q", got)
+	}
+	if got := listing("/snapshots/2025/01"); got != "01" {
+		t.Errorf("Expected the day in UTC, got %q", got)
+	}
+	if _, err := lookupPath(fsys, "/snapshots/2025/01/01/sensors/a.json"); err != nil {
+		t.Errorf("Listed day should resolve the file: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package djafs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// spillDir is the directory below incoming holding files being written. The
+// name is reserved: /live neither shows nor accepts a path below it.
+const spillDir = ".djafs-spill"
+
+// reserved reports whether logical path p is taken by the hot cache itself
+func reserved(p string) bool {
+	return p == spillDir || strings.HasPrefix(p, spillDir+"/")
+}
+
+// spillPath returns the directory holding files being written
+func (hc *HotCache) spillPath() string {
+	return filepath.Join(hc.IncomingDir, spillDir)
+}
+
+// clearSpill removes files left behind by a mount that never flushed them
+func (hc *HotCache) clearSpill() {
+	if err := os.RemoveAll(hc.spillPath()); err != nil {
+		fmt.Printf("Error removing unflushed writes: %v\n", err)
+	}
+}
+
+// CreateTemp creates an empty file in the hot cache for Commit to move into
+// place once written
+func (hc *HotCache) CreateTemp() (*os.File, error) {
+	if err := os.MkdirAll(hc.spillPath(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory structure: %w", err)
+	}
+	return os.CreateTemp(hc.spillPath(), "write-*")
+}
+
+// Steps of a durable Commit. HotCache.syncHook is called before each one,
+// so tests can stop a commit there as a crash would.
+const (
+	stepSyncFile = "sync file"
+	stepRename   = "rename"
+	stepSyncDir  = "sync dir"
+)
+
+// step reports a durable Commit step to the test hook, whose error is
+// returned as that of the step would be
+func (hc *HotCache) step(name string) error {
+	if hc.syncHook == nil {
+		return nil
+	}
+	return errnoOf(hc.syncHook(name))
+}
+
+// Commit atomically makes tmp, created by CreateTemp, the newest hot cache
+// copy of logical path p. Content only reaches incoming by this rename, so
+// the garbage collector and readers never see a half-written file. A
+// durable commit returns only once the content and the directory entries
+// leading to it are on stable storage.
+func (hc *HotCache) Commit(p string, tmp *os.File, durable bool) error {
+	if reserved(p) {
+		return syscall.EPERM
+	}
+
+	// The content must be stable before a name points at it
+	if durable {
+		if err := hc.step(stepSyncFile); err != nil {
+			return err
+		}
+		if err := tmp.Sync(); err != nil {
+			return errnoOf(err)
+		}
+	}
+
+	// A new file waits for room in the hot cache, replacing one doesn't
+	fullPath := filepath.Join(hc.IncomingDir, p)
+	if !isFile(fullPath) {
+		if err := hc.admit(); err != nil {
+			return err
+		}
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return errnoOf(err)
+	}
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+
+	// What the hot cache gains, a replaced file makes room for its successor
+	added, grown := int64(1), info.Size()
+	if old, err := os.Stat(fullPath); err == nil {
+		added, grown = 0, grown-old.Size()
+	}
+	if err := hc.fits(added, grown); err != nil {
+		return err
+	}
+	created := missingDirs(filepath.Dir(fullPath))
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return errnoOf(err)
+	}
+	if err := hc.step(stepRename); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return errnoOf(err)
+	}
+	hc.backlog.Add(added)
+	hc.backlogSize.Add(grown)
+	hc.checkThresholds()
+	hc.checkHighWater()
+	if !durable {
+		return nil
+	}
+
+	// The new name, then every directory created for it in its parent
+	if err := hc.step(stepSyncDir); err != nil {
+		return err
+	}
+	for _, dir := range append([]string{fullPath}, created...) {
+		if err := syncDir(filepath.Dir(dir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync flushes the newest hot cache copy of the file at logical path p and
+// its directory entry to stable storage. Files not in the hot cache are left
+// alone.
+func (hc *HotCache) Sync(p string) error {
+	if hc == nil {
+		return nil
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+
+	hf, ok := hc.stat(p)
+	if !ok {
+		return nil
+	}
+	f, err := os.Open(hf.path)
+	if err != nil {
+		return errnoOf(err)
+	}
+	err = f.Sync()
+	f.Close()
+	if err != nil {
+		return errnoOf(err)
+	}
+	return syncDir(filepath.Dir(hf.path))
+}
+
+// missingDirs returns dir and those of its parents that don't exist yet,
+// deepest first
+func missingDirs(dir string) []string {
+	var missing []string
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return missing
+		}
+		missing = append(missing, dir)
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return missing
+		}
+		dir = parent
+	}
+}
+
+// syncDir flushes the entries of directory dir to stable storage
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errnoOf(err)
+	}
+	err = d.Sync()
+	d.Close()
+	return errnoOf(err)
+}
+
+// errnoOf returns the errno behind err, so the kernel is told the cause
+// rather than an I/O error
+func errnoOf(err error) error {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package djafs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+
+	"bazil.org/fuse"
+)
+
+var errCrash = errors.New("simulated crash")
+
+func TestFsync_CrashPoints(t *testing.T) {
+	tests := []struct {
+		crashAt string
+		want    string // Content found after remounting
+	}{
+		{crashAt: stepSyncFile, want: "old"},
+		{crashAt: stepRename, want: "old"},
+		{crashAt: stepSyncDir, want: "new content"},
+		{crashAt: "", want: "new content"},
+	}
+
+	for _, tt := range tests {
+		name := tt.crashAt
+		if name == "" {
+			name = "no crash"
+		}
+		t.Run(name, func(t *testing.T) {
+			storage := t.TempDir()
+			fsys := NewFS(storage)
+			if err := fsys.HotCache.WriteFile("sensors/a.json", []byte("old")); err != nil {
+				t.Fatalf("WriteFile failed: %v", err)
+			}
+
+			var steps []string
+			fsys.HotCache.syncHook = func(step string) error {
+				steps = append(steps, step)
+				if step == tt.crashAt {
+					return errCrash
+				}
+				return nil
+			}
+
+			node, err := lookupPath(fsys, "/live/sensors/a.json")
+			if err != nil {
+				t.Fatalf("Lookup failed: %v", err)
+			}
+			open := &fuse.OpenRequest{Flags: fuse.OpenWriteOnly | fuse.OpenTruncate}
+			h, err := node.(*File).Open(context.Background(), open, &fuse.OpenResponse{})
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			writeHandle(t, h.(*FileHandle), 0, "new content")
+			err = node.(*File).Fsync(context.Background(), &fuse.FsyncRequest{})
+
+			// fsync may only succeed after every step
+			if tt.crashAt != "" && !errors.Is(err, errCrash) {
+				t.Errorf("Expected fsync to fail at %s, got %v", tt.crashAt, err)
+			}
+			if tt.crashAt == "" {
+				if err != nil {
+					t.Fatalf("Fsync failed: %v", err)
+				}
+				if want := []string{stepSyncFile, stepRename, stepSyncDir}; !slices.Equal(steps, want) {
+					t.Errorf("Expected steps %v, got %v", want, steps)
+				}
+			}
+
+			// Remount without releasing the handle, as after a power loss
+			fsys.Stop()
+			fsys = NewFS(storage)
+			defer fsys.Stop()
+
+			if got := hotContent(t, fsys, "sensors/a.json"); got != tt.want {
+				t.Errorf("Expected %q after remounting, got %q", tt.want, got)
+			}
+			if entries, _ := os.ReadDir(fsys.HotCache.spillPath()); len(entries) != 0 {
+				t.Errorf("Expected unflushed writes to be dropped, found %d", len(entries))
+			}
+		})
+	}
+}
+
+func TestFsync_ReportsErrno(t *testing.T) {
+	for _, step := range []string{stepSyncFile, stepSyncDir} {
+		fsys := NewFS(t.TempDir())
+		fsys.HotCache.syncHook = func(s string) error {
+			if s == step {
+				return &os.PathError{Op: "fsync", Path: s, Err: syscall.EDQUOT}
+			}
+			return nil
+		}
+
+		_, handle, err := (&Dir{fs: fsys, path: "/live"}).Create(context.Background(), &fuse.CreateRequest{Name: "a.json"}, &fuse.CreateResponse{})
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		h := handle.(*FileHandle)
+		writeHandle(t, h, 0, "{}")
+		err = h.file.Fsync(context.Background(), &fuse.FsyncRequest{})
+		if fuse.ToErrno(err) != fuse.Errno(syscall.EDQUOT) {
+			t.Errorf("Failing %s: expected EDQUOT, got %v", step, err)
+		}
+		h.Release(context.Background(), &fuse.ReleaseRequest{})
+		fsys.Stop()
+	}
+}
+
+func TestFlush_SyncOnFlush(t *testing.T) {
+	for _, syncOnFlush := range []bool{false, true} {
+		opts := DefaultOptions()
+		opts.SyncOnFlush = syncOnFlush
+		fsys := NewFSWithOptions(t.TempDir(), opts)
+
+		var steps []string
+		fsys.HotCache.syncHook = func(step string) error {
+			steps = append(steps, step)
+			return nil
+		}
+
+		_, handle, err := (&Dir{fs: fsys, path: "/live"}).Create(context.Background(), &fuse.CreateRequest{Name: "a.json"}, &fuse.CreateResponse{})
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		writeHandle(t, handle.(*FileHandle), 0, "{}")
+		closeHandle(t, handle.(*FileHandle))
+		fsys.Stop()
+
+		if synced := slices.Contains(steps, stepSyncDir); synced != syncOnFlush {
+			t.Errorf("SyncOnFlush %v: got steps %v", syncOnFlush, steps)
+		}
+	}
+}
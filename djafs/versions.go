@@ -0,0 +1,122 @@
+package djafs
+
+import (
+	"slices"
+	"strings"
+	"syscall"
+
+	"bazil.org/fuse"
+	"bazil.org/fuse/fs"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+// versionLayout is the time format of revision names
+const versionLayout = "2006-01-02T15:04:05Z"
+
+// versionHashLength is how much of the content hash revision names show
+const versionHashLength = 8
+
+// versionName names a revision of a file in /versions by its modification
+// time and short content hash, e.g. "2024-03-05T14:30:00Z_1a2b3c4d".
+// Deletions end in "_deleted".
+func versionName(entry util.LookupEntry) string {
+	hash := "deleted"
+	if entry.Target != "" {
+		// Targets are "bucket-subbucket-hash"
+		hash = entry.Target[strings.LastIndex(entry.Target, "-")+1:]
+		if len(hash) > versionHashLength {
+			hash = hash[:versionHashLength]
+		}
+	}
+	return entry.Modified.UTC().Format(versionLayout) + "_" + hash
+}
+
+// versionHistory returns the revisions recorded for logical path p, oldest
+// first, and the names ever recorded directly below it. Directory entries,
+// and the tombstones of removed or renamed directories, aren't revisions.
+func (fs *FS) versionHistory(p string) ([]util.LookupEntry, []string, error) {
+	history, err := fs.recordedEntries(p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	slices.SortStableFunc(history, func(a, b util.LookupEntry) int {
+		return a.Modified.Compare(b.Modified)
+	})
+	var versions []util.LookupEntry
+	wasDir := false
+	for _, entry := range history {
+		isDir := entry.IsDir()
+		if !isDir && !(wasDir && entry.Target == "") {
+			versions = append(versions, entry)
+		}
+		wasDir = isDir
+	}
+	return versions, fs.Index.Names(p), nil
+}
+
+// versionPath converts a /versions directory path to the logical path it
+// shows the history of
+func versionPath(dirPath string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(dirPath, "/versions"), "/")
+}
+
+// resolveVersionPath resolves a revision or a child path within /versions
+func (d *Dir) resolveVersionPath(name string) (fs.Node, error) {
+	versions, children, err := d.fs.versionHistory(versionPath(d.path))
+	if err != nil {
+		return nil, err
+	}
+
+	// Later revisions win if two share a name
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versionName(versions[i]) == name {
+			return &File{
+				fs:       d.fs,
+				entry:    &versions[i],
+				snapshot: true,
+			}, nil
+		}
+	}
+
+	if _, ok := slices.BinarySearch(children, name); ok {
+		return &Dir{
+			fs:   d.fs,
+			path: d.path + "/" + name,
+		}, nil
+	}
+
+	return nil, syscall.ENOENT
+}
+
+// versionDirents lists the revisions and children of a path within /versions
+func (d *Dir) versionDirents() ([]fuse.Dirent, error) {
+	versions, children, err := d.fs.versionHistory(versionPath(d.path))
+	if err != nil {
+		return nil, err
+	}
+
+	var dirents []fuse.Dirent
+	seen := make(map[string]bool, len(versions))
+	for _, entry := range versions {
+		name := versionName(entry)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		dirents = append(dirents, fuse.Dirent{
+			Inode: entry.Inode,
+			Name:  name,
+			Type:  fuse.DT_File,
+		})
+	}
+	for _, child := range children {
+		dirents = append(dirents, fuse.Dirent{
+			Inode: d.fs.Inodes.Get(d.path + "/" + child),
+			Name:  child,
+			Type:  fuse.DT_Dir,
+		})
+	}
+
+	return dirents, nil
+}
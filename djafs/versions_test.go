@@ -0,0 +1,99 @@
+package djafs
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+func TestVersions_ListsEveryRevision(t *testing.T) {
+	storage := t.TempDir()
+	first := util.HashPathFromHash(strings.Repeat("a", 64))
+	second := util.HashPathFromHash(strings.Repeat("b", 64))
+	created := time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC)
+	writeLookupTable(t, filepath.Join(storage, "sensors"),
+		util.LookupEntry{Name: "a.json", Target: first, FileSize: 5, Modified: created},
+		util.LookupEntry{Name: "a.json", Target: second, FileSize: 6, Modified: created.Add(time.Hour)},
+		util.LookupEntry{Name: "a.json", Modified: created.Add(2 * time.Hour)},
+		util.LookupEntry{Name: "sub/b.json", Target: first, FileSize: 5, Modified: created},
+		// A directory later made and removed in its place adds no revision
+		util.LookupEntry{Name: "a.json", Target: util.DirTarget, Modified: created.Add(3 * time.Hour)},
+		util.LookupEntry{Name: "a.json", Modified: created.Add(4 * time.Hour)},
+	)
+	writeLookupTable(t, filepath.Join(storage, "other"),
+		util.LookupEntry{Name: "c.json", Target: first, FileSize: 5, Modified: created},
+	)
+	archivePath := filepath.Join(storage, "data", "1-00000.djfz")
+	os.MkdirAll(filepath.Dir(archivePath), 0o755)
+	writeTestArchive(t, archivePath, map[string][]byte{first: []byte("first"), second: []byte("second")}, zip.Deflate)
+
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+	fsys.Targets.Set(first, archivePath)
+	fsys.Targets.Set(second, archivePath)
+
+	ctx := context.Background()
+	root, _ := fsys.Root()
+	if _, err := root.(*Dir).Lookup(ctx, "versions"); err != nil {
+		t.Fatalf("Lookup versions failed: %v", err)
+	}
+
+	node, err := lookupPath(fsys, "/versions/sensors/a.json")
+	if err != nil {
+		t.Fatalf("Lookup of a deleted file's history failed: %v", err)
+	}
+	dirents, err := node.(*Dir).ReadDirAll(ctx)
+	if err != nil {
+		t.Fatalf("ReadDirAll failed: %v", err)
+	}
+	want := []string{
+		"2024-03-05T14:30:00Z_aaaaaaaa",
+		"2024-03-05T15:30:00Z_bbbbbbbb",
+		"2024-03-05T16:30:00Z_deleted",
+	}
+	if len(dirents) != len(want) {
+		t.Fatalf("Expected %d revisions, got %+v", len(want), dirents)
+	}
+	for i, d := range dirents {
+		if d.Name != want[i] || d.Type != fuse.DT_File {
+			t.Errorf("Revision %d: expected file %s, got %+v", i, want[i], d)
+		}
+	}
+
+	contents := map[string]string{want[1]: "second", want[2]: ""}
+	for name, content := range contents {
+		revision, err := node.(*Dir).Lookup(ctx, name)
+		if err != nil {
+			t.Fatalf("Lookup %s failed: %v", name, err)
+		}
+		if got := readNode(t, revision); got != content {
+			t.Errorf("Read %q from %s, want %q", got, name, content)
+		}
+	}
+
+	dirents, _ = (&Dir{fs: fsys, path: "/versions/sensors"}).ReadDirAll(ctx)
+	if len(dirents) != 2 || dirents[0].Name != "a.json" || dirents[1].Name != "sub" {
+		t.Errorf("Expected a.json and sub in the history, got %+v", dirents)
+	}
+	if _, err := lookupPath(fsys, "/versions/sensors/missing.json"); !errors.Is(err, syscall.ENOENT) {
+		t.Errorf("Expected ENOENT, got %v", err)
+	}
+
+	// Only the lookup table holding the file is read
+	if entries := fsys.Stats().LookupCache.Entries; entries != 1 {
+		t.Errorf("Expected only the sensors lookup table to be read, %d were", entries)
+	}
+	dirents, _ = (&Dir{fs: fsys, path: "/versions"}).ReadDirAll(ctx)
+	if len(dirents) != 2 || dirents[0].Name != "other" || dirents[1].Name != "sensors" {
+		t.Errorf("Expected other and sensors in the history, got %+v", dirents)
+	}
+}
@@ -0,0 +1,128 @@
+package djafs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"bazil.org/fuse"
+)
+
+// xattrPrefix namespaces the extended attributes djafs exposes
+const xattrPrefix = "user.djafs."
+
+// Getxattr returns a djafs extended attribute of the file
+func (f *File) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return getxattr(f.xattrs(), req, resp)
+}
+
+// Listxattr lists the djafs extended attributes of the file
+func (f *File) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	listxattr(f.xattrs(), resp)
+	return nil
+}
+
+// Getxattr returns a djafs extended attribute of the directory
+func (d *Dir) Getxattr(ctx context.Context, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	return getxattr(d.xattrs(), req, resp)
+}
+
+// Listxattr lists the djafs extended attributes of the directory
+func (d *Dir) Listxattr(ctx context.Context, req *fuse.ListxattrRequest, resp *fuse.ListxattrResponse) error {
+	listxattr(d.xattrs(), resp)
+	return nil
+}
+
+func getxattr(attrs map[string]string, req *fuse.GetxattrRequest, resp *fuse.GetxattrResponse) error {
+	value, ok := attrs[req.Name]
+	if !ok {
+		return fuse.ErrNoXattr
+	}
+	resp.Xattr = []byte(value)
+	return nil
+}
+
+func listxattr(attrs map[string]string, resp *fuse.ListxattrResponse) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	resp.Append(names...)
+}
+
+// xattrs returns the extended attributes of an archived file: its target,
+// sha256, archive, boundary and compressed_size. Files not archived yet
+// have none.
+func (f *File) xattrs() map[string]string {
+	f.mu.RLock()
+	if f.isNew || f.isHot || f.entry == nil || f.entry.Target == "" {
+		f.mu.RUnlock()
+		return nil
+	}
+	entry := *f.entry
+	f.mu.RUnlock()
+
+	attrs := map[string]string{
+		xattrPrefix + "target": entry.Target,
+		// Targets are "bucket-subbucket-hash"
+		xattrPrefix + "sha256": entry.Target[strings.LastIndex(entry.Target, "-")+1:],
+	}
+
+	manifestPath, ok := f.fs.Index.Manifest(entry.Name)
+	if !ok {
+		manifestPath = f.fs.Index.Boundary(entry.Name)
+	}
+	attrs[xattrPrefix+"boundary"] = filepath.Dir(manifestPath)
+
+	archivePath, err := f.fs.findArchiveForTarget(entry.Target)
+	if err != nil {
+		return attrs
+	}
+	attrs[xattrPrefix+"archive"] = archivePath
+
+	archive, err := f.fs.pool.Acquire(archivePath)
+	if err != nil {
+		return attrs
+	}
+	defer f.fs.pool.Release(archive)
+	if zf, ok := archive.File(entry.Target); ok {
+		attrs[xattrPrefix+"compressed_size"] = strconv.FormatUint(zf.CompressedSize64, 10)
+	}
+
+	return attrs
+}
+
+// xattrs returns the fields of the metadata.djfm of a /live directory that
+// is a boundary, such as total_file_count
+func (d *Dir) xattrs() map[string]string {
+	if d.path != "/live" && !strings.HasPrefix(d.path, "/live/") {
+		return nil
+	}
+
+	boundary := filepath.Join(d.fs.StoragePath, filepath.FromSlash(livePath(d.path)))
+	data, err := os.ReadFile(filepath.Join(boundary, "metadata.djfm"))
+	if err != nil {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+
+	attrs := map[string]string{xattrPrefix + "boundary": boundary}
+	for name, raw := range fields {
+		// Strings such as timestamps lose their quotes, numbers stay as written
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			attrs[xattrPrefix+name] = s
+		} else {
+			attrs[xattrPrefix+name] = string(raw)
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,95 @@
+package djafs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/dendrascience/dendra-archive-fuse/util"
+)
+
+func TestXattrs(t *testing.T) {
+	storage := t.TempDir()
+	hash := strings.Repeat("c", 64)
+	target := util.HashPathFromHash(hash)
+	boundary := filepath.Join(storage, "sensors")
+	writeLookupTable(t, boundary,
+		util.LookupEntry{Name: "a.json", Target: target, FileSize: 4096, Modified: time.Now()},
+	)
+	metadata := util.Metadata{TotalFileCount: 1, NewestFileTS: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)}
+	if err := util.WriteJSONFile(filepath.Join(boundary, "metadata.djfm"), metadata); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	archivePath := filepath.Join(storage, "data", "1-00000.djfz")
+	os.MkdirAll(filepath.Dir(archivePath), 0o755)
+	writeTestArchive(t, archivePath, map[string][]byte{target: testContent(4096)}, zip.Deflate)
+
+	fsys := NewFS(storage)
+	defer fsys.Stop()
+	fsys.Targets.Set(target, archivePath)
+
+	ctx := context.Background()
+	get := func(node interface {
+		Getxattr(context.Context, *fuse.GetxattrRequest, *fuse.GetxattrResponse) error
+	}, name string) (string, error) {
+		resp := &fuse.GetxattrResponse{}
+		err := node.Getxattr(ctx, &fuse.GetxattrRequest{Name: name}, resp)
+		return string(resp.Xattr), err
+	}
+
+	node, err := lookupPath(fsys, "/live/sensors/a.json")
+	if err != nil {
+		t.Fatalf("Lookup failed: %v", err)
+	}
+	file := node.(*File)
+	want := map[string]string{
+		"user.djafs.target":   target,
+		"user.djafs.sha256":   hash,
+		"user.djafs.archive":  archivePath,
+		"user.djafs.boundary": boundary,
+	}
+	for name, value := range want {
+		if got, err := get(file, name); err != nil || got != value {
+			t.Errorf("%s = %q (%v), want %q", name, got, err, value)
+		}
+	}
+	if size, err := get(file, "user.djafs.compressed_size"); err != nil || size == "0" || size == "4096" {
+		t.Errorf("Expected the compressed member size, got %q (%v)", size, err)
+	}
+	if _, err := get(file, "user.djafs.missing"); !errors.Is(err, fuse.ErrNoXattr) {
+		t.Errorf("Expected ErrNoXattr, got %v", err)
+	}
+
+	list := &fuse.ListxattrResponse{}
+	file.Listxattr(ctx, &fuse.ListxattrRequest{}, list)
+	if got := string(bytes.ReplaceAll(list.Xattr, []byte{0}, []byte(","))); got !=
+		"user.djafs.archive,user.djafs.boundary,user.djafs.compressed_size,user.djafs.sha256,user.djafs.target," {
+		t.Errorf("Unexpected attribute list %q", got)
+	}
+
+	// Boundary directories expose their metadata
+	dir := &Dir{fs: fsys, path: "/live/sensors"}
+	if got, err := get(dir, "user.djafs.total_file_count"); err != nil || got != "1" {
+		t.Errorf("total_file_count = %q (%v)", got, err)
+	}
+	if got, err := get(dir, "user.djafs.newest_file_ts"); err != nil || got != "2024-03-05T00:00:00Z" {
+		t.Errorf("newest_file_ts = %q (%v)", got, err)
+	}
+	if _, err := get(&Dir{fs: fsys, path: "/live"}, "user.djafs.total_file_count"); !errors.Is(err, fuse.ErrNoXattr) {
+		t.Errorf("Expected no metadata outside boundaries, got %v", err)
+	}
+
+	// Files still in the hot cache aren't archived anywhere
+	fsys.HotCache.WriteFile("sensors/new.json", []byte("{}"))
+	node, _ = lookupPath(fsys, "/live/sensors/new.json")
+	if _, err := get(node.(*File), "user.djafs.target"); !errors.Is(err, fuse.ErrNoXattr) {
+		t.Errorf("Expected no target for a hot cache file, got %v", err)
+	}
+}
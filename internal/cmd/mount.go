@@ -6,7 +6,9 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"bazil.org/fuse"
 	"bazil.org/fuse/fs"
@@ -19,16 +21,191 @@ import (
 // NewMountCmd creates and returns the mount subcommand for the djafs CLI.
 // It handles mounting djafs filesystems at specified mountpoints.
 func NewMountCmd() *cobra.Command {
-	return &cobra.Command{
+	var (
+		lookupCacheSize  string
+		contentCacheSize string
+		writeBufferSize  string
+		readOnly         bool
+		syncOnClose      bool
+		gcWorkers        int
+		queueFiles       int
+		queueWait        time.Duration
+		gcInterval       time.Duration
+		packFiles        int
+		packSize         string
+		packAge          time.Duration
+		minPackAge       time.Duration
+		quietWindows     []string
+		quotaFiles       int
+		quotaSize        string
+		quotaHighWater   float64
+		at               string
+		uid, gid         int
+		umask            string
+	)
+
+	cmd := &cobra.Command{
 		Use:   "mount STORAGE_PATH MOUNTPOINT",
 		Short: "Mount a djafs filesystem",
 		Long: `Mount a djafs filesystem at the specified mountpoint.
 
 STORAGE_PATH is the path to the djafs storage directory.
-MOUNTPOINT is the directory where the filesystem will be mounted.`,
+MOUNTPOINT is the directory where the filesystem will be mounted.
+
+Lookup tables and small decompressed files are kept in memory caches bounded
+by --lookup-cache-size and --content-cache-size. Sizes accept K, M and G
+suffixes (e.g. 512M); 0 disables a cache. Cache statistics are logged on
+shutdown.
+
+Each file open for writing is buffered in memory up to --write-buffer-size;
+larger files are written to a temporary file in the hot cache instead, which
+is renamed into place on close. fsync returns once the file and its directory
+entry are on stable storage; --sync-on-close makes every close do the same.
+
+Written files wait in the hot cache until the garbage collector archives
+them, --gc-workers files at a time. Once --queue-files files are waiting, a
+new file is held back until a collection makes room; after --queue-wait its
+write fails with EAGAIN.
+
+Every --gc-interval the garbage collector packs the hot cache into archives
+if it holds --pack-files files or --pack-size bytes, or its oldest file is
+--pack-age old; crossing --pack-files or --pack-size starts packing right
+away. Files younger than --min-pack-age are left for a later collection.
+--quiet-window defers packing during a recurring period, given as a cron
+schedule of when it opens and how long it stays open, e.g. "0 1 * * * 3h"
+for 01:00 to 04:00 every night; repeat it for several windows. Only writes
+held back by --queue-files pack during a quiet window.
+
+--quota-files and --quota-size bound the hot cache, so a garbage collector
+that falls behind can't fill the disk: a write or close that would exceed
+either fails with ENOSPC, counting large files still being written. Usage
+above the --quota-high-water fraction of a quota is logged as a warning, and
+hot cache usage is logged on shutdown.
+
+--read-only serves the archived files without a hot cache or garbage
+collector and never writes to STORAGE_PATH. --at mounts the snapshot at an
+RFC 3339 instant (e.g. 2024-03-05T14:30:00Z) as the root; it implies
+--read-only.
+
+Files and directories keep the mode and owner they are created with or given
+by chmod and chown. Those converted from archives without that data are owned
+by --uid and --gid and get 0666 and 0777 less the octal --umask.`,
 		Args: cobra.ExactArgs(2),
-		Run:  runMount,
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := djafs.DefaultOptions()
+			var err error
+			if opts.LookupCacheBytes, err = parseSize(lookupCacheSize); err != nil {
+				log.Fatalf("Invalid --lookup-cache-size: %v", err)
+			}
+			if opts.ContentCacheBytes, err = parseSize(contentCacheSize); err != nil {
+				log.Fatalf("Invalid --content-cache-size: %v", err)
+			}
+			if opts.WriteBufferBytes, err = parseSize(writeBufferSize); err != nil {
+				log.Fatalf("Invalid --write-buffer-size: %v", err)
+			}
+			if at != "" {
+				if opts.At, err = time.Parse(time.RFC3339, at); err != nil {
+					log.Fatalf("Invalid --at, expected an RFC 3339 timestamp: %v", err)
+				}
+			}
+			opts.ReadOnly = readOnly || at != ""
+			opts.SyncOnFlush = syncOnClose
+			if gcWorkers > 0 {
+				opts.GCWorkers = gcWorkers
+			}
+			opts.QueueFiles = queueFiles
+			opts.QueueWait = queueWait
+			if gcInterval <= 0 {
+				log.Fatalf("Invalid --gc-interval, must be positive: %v", gcInterval)
+			}
+			opts.GCInterval = gcInterval
+			opts.PackFiles = packFiles
+			if opts.PackBytes, err = parseSize(packSize); err != nil {
+				log.Fatalf("Invalid --pack-size: %v", err)
+			}
+			opts.PackAge = packAge
+			opts.MinPackAge = minPackAge
+			for _, spec := range quietWindows {
+				window, err := djafs.ParseQuietWindow(spec)
+				if err != nil {
+					log.Fatalf("Invalid --quiet-window: %v", err)
+				}
+				opts.QuietWindows = append(opts.QuietWindows, window)
+			}
+			opts.QuotaFiles = quotaFiles
+			if opts.QuotaBytes, err = parseSize(quotaSize); err != nil {
+				log.Fatalf("Invalid --quota-size: %v", err)
+			}
+			if quotaHighWater <= 0 || quotaHighWater > 1 {
+				log.Fatalf("Invalid --quota-high-water, expected a fraction such as 0.9: %v", quotaHighWater)
+			}
+			opts.QuotaHighWater = quotaHighWater
+			if uid >= 0 {
+				opts.Uid = uint32(uid)
+			}
+			if gid >= 0 {
+				opts.Gid = uint32(gid)
+			}
+			mask, err := strconv.ParseUint(umask, 8, 32)
+			if err != nil || mask > 0o777 {
+				log.Fatalf("Invalid --umask, expected octal permission bits such as 022: %q", umask)
+			}
+			opts.Umask = os.FileMode(mask)
+			runMount(args[0], args[1], opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&lookupCacheSize, "lookup-cache-size", "64M", "Memory limit for cached lookup tables")
+	cmd.Flags().StringVar(&contentCacheSize, "content-cache-size", "256M", "Memory limit for cached file content")
+	cmd.Flags().StringVar(&writeBufferSize, "write-buffer-size", "8M", "Memory limit for buffering each file open for writing")
+	cmd.Flags().BoolVar(&syncOnClose, "sync-on-close", false, "Make every close of a written file durable, like fsync")
+	cmd.Flags().IntVar(&gcWorkers, "gc-workers", 0, "Files the garbage collector processes at once (default: number of CPUs)")
+	cmd.Flags().IntVar(&queueFiles, "queue-files", 100000, "Files waiting to be archived before writes are held back, 0 for no limit")
+	cmd.Flags().DurationVar(&queueWait, "queue-wait", 10*time.Second, "How long a held back write waits before failing with EAGAIN")
+	cmd.Flags().DurationVar(&gcInterval, "gc-interval", 30*time.Second, "How often the garbage collector checks whether to pack the hot cache")
+	cmd.Flags().IntVar(&packFiles, "pack-files", 1000, "Pack the hot cache once it holds this many files, 0 to disable")
+	cmd.Flags().StringVar(&packSize, "pack-size", "64M", "Pack the hot cache once it holds this many bytes, 0 to disable")
+	cmd.Flags().DurationVar(&packAge, "pack-age", 10*time.Minute, "Pack the hot cache once its oldest file is this old, 0 to disable")
+	cmd.Flags().DurationVar(&minPackAge, "min-pack-age", 0, "Leave files younger than this in the hot cache")
+	cmd.Flags().StringArrayVar(&quietWindows, "quiet-window", nil, `Defer packing during a recurring window, e.g. "0 1 * * * 3h"`)
+	cmd.Flags().IntVar(&quotaFiles, "quota-files", 0, "Most files the hot cache may hold, 0 for no limit")
+	cmd.Flags().StringVar(&quotaSize, "quota-size", "0", "Most bytes the hot cache may hold, 0 for no limit")
+	cmd.Flags().Float64Var(&quotaHighWater, "quota-high-water", 0.9, "Fraction of a quota above which a warning is logged")
+	cmd.Flags().BoolVar(&readOnly, "read-only", false, "Mount read-only, without a hot cache")
+	cmd.Flags().StringVar(&at, "at", "", "Mount only the snapshot at this RFC 3339 timestamp")
+	cmd.Flags().IntVar(&uid, "uid", -1, "Owner of files without a recorded owner (default: current user)")
+	cmd.Flags().IntVar(&gid, "gid", -1, "Group of files without a recorded owner (default: current group)")
+	cmd.Flags().StringVar(&umask, "umask", "022", "Octal umask for files without a recorded mode")
+
+	return cmd
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix (powers of 1024)
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "B")
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	multiplier := int64(1)
+	switch s[len(s)-1] {
+	case 'K':
+		multiplier = 1 << 10
+	case 'M':
+		multiplier = 1 << 20
+	case 'G':
+		multiplier = 1 << 30
+	}
+	if multiplier > 1 {
+		s = s[:len(s)-1]
 	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
 }
 
 // pathsOverlap checks if two paths overlap (one contains the other).
@@ -58,31 +235,35 @@ func pathsOverlap(path1, path2 string) bool {
 	return strings.HasPrefix(abs1, abs2) || strings.HasPrefix(abs2, abs1)
 }
 
-func runMount(cmd *cobra.Command, args []string) {
+func runMount(storagePath, mountpoint string, opts djafs.Options) {
 	// Print version info on startup
 	fmt.Printf("djafs %s starting...\n", version.GetFullVersion())
 
-	storagePath := args[0]
-	mountpoint := args[1]
-
 	// Validate that storage path and mountpoint don't overlap
 	if pathsOverlap(storagePath, mountpoint) {
 		log.Fatalf("Storage path and mountpoint cannot overlap: storage=%s, mount=%s", storagePath, mountpoint)
 	}
 
-	// Ensure storage directory exists
-	if err := os.MkdirAll(storagePath, 0755); err != nil {
+	// Ensure storage directory exists, read-only mounts must not create it
+	if opts.ReadOnly {
+		if _, err := os.Stat(storagePath); err != nil {
+			log.Fatalf("Failed to open storage directory: %v", err)
+		}
+	} else if err := os.MkdirAll(storagePath, 0755); err != nil {
 		log.Fatalf("Failed to create storage directory: %v", err)
 	}
 
 	// Create filesystem instance
-	filesystem := djafs.NewFS(storagePath)
+	filesystem := djafs.NewFSWithOptions(storagePath, opts)
 
-	c, err := fuse.Mount(
-		mountpoint,
+	mountOptions := []fuse.MountOption{
 		fuse.FSName("djafs"),
 		fuse.Subtype("djafs"),
-	)
+	}
+	if opts.ReadOnly {
+		mountOptions = append(mountOptions, fuse.ReadOnly())
+	}
+	c, err := fuse.Mount(mountpoint, mountOptions...)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -96,6 +277,7 @@ func runMount(cmd *cobra.Command, args []string) {
 
 		// Stop filesystem gracefully
 		filesystem.Stop()
+		logStats(filesystem.Stats())
 
 		// Unmount filesystem
 		fuse.Unmount(mountpoint)
@@ -111,3 +293,21 @@ func runMount(cmd *cobra.Command, args []string) {
 		log.Fatal(err)
 	}
 }
+
+// logStats reports cache effectiveness and hot cache usage
+func logStats(stats djafs.Stats) {
+	for _, c := range []struct {
+		name  string
+		stats djafs.CacheStats
+	}{
+		{"lookup", stats.LookupCache},
+		{"content", stats.ContentCache},
+	} {
+		log.Printf("%s cache: %d hits, %d misses, %d evictions, %d entries, %d/%d bytes",
+			c.name, c.stats.Hits, c.stats.Misses, c.stats.Evictions, c.stats.Entries, c.stats.Bytes, c.stats.Limit)
+	}
+	hot := stats.HotCache
+	log.Printf("hot cache: %d/%d files, %d/%d bytes, %d bytes being written, %d writes refused",
+		hot.Files, hot.QuotaFiles, hot.Bytes, hot.QuotaBytes, hot.Spilled, hot.Rejected)
+	log.Printf("archive pool: %d archives open", stats.OpenArchives)
+}
@@ -64,3 +64,32 @@ func TestPathsOverlap(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "4096", want: 4096},
+		{in: "64K", want: 64 << 10},
+		{in: "512M", want: 512 << 20},
+		{in: "2g", want: 2 << 30},
+		{in: "1GB", want: 1 << 30},
+		{in: "", wantErr: true},
+		{in: "lots", wantErr: true},
+		{in: "-1M", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
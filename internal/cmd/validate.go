@@ -102,6 +102,9 @@ This command checks the structure of .djfz archives, verifies that all files
 referenced in lookup tables exist, and validates metadata consistency.
 Optionally can attempt repairs on corrupted archives.
 
+The target index (targets.djfi), which maps content hashes to the archive
+holding them, is rebuilt from the archives found unless --dry-run is given.
+
 Repair operations:
   - Regenerate metadata from lookup table
   - Remove orphaned files from archive
@@ -234,11 +237,24 @@ func runValidate(storagePath string, opts ValidateOptions) {
 		log.Fatalf("Error walking storage directory: %v", err)
 	}
 
+	// Rebuild the target index now that archives are known to be consistent
+	indexedTargets := -1
+	if !opts.DryRun {
+		indexedTargets, err = util.RebuildTargetIndex(storagePath)
+		if err != nil {
+			fmt.Printf("Warning: failed to rebuild target index: %v\n", err)
+			indexedTargets = -1
+		}
+	}
+
 	// Print summary
 	fmt.Printf("\nValidation complete:\n")
 	fmt.Printf("  Archives checked: %d\n", totalArchives)
 	fmt.Printf("  Archives with errors: %d\n", archivesWithErrors)
 	fmt.Printf("  Total errors: %d\n", totalErrors)
+	if indexedTargets >= 0 {
+		fmt.Printf("  Targets indexed: %d\n", indexedTargets)
+	}
 	if opts.Repair {
 		if opts.DryRun {
 			fmt.Printf("  (dry-run mode - no repairs were made)\n")
@@ -313,8 +329,8 @@ func validateArchive(archivePath string) []ValidationError {
 	if hasLookup && !lookupParseError {
 		referencedFiles := make(map[string]bool)
 		for entry := range lookupTable.Iterate {
-			if entry.Target == "" {
-				continue // Deleted file
+			if entry.Target == "" || entry.IsDir() {
+				continue // Deleted file or directory
 			}
 			referencedFiles[entry.Target] = true
 			if !archiveFiles[entry.Target] {
@@ -515,7 +531,7 @@ func repairArchive(archivePath string, validationErrors []ValidationError, verbo
 	// Build set of valid targets from lookup table
 	validTargets := make(map[string]bool)
 	for entry := range lookupTable.Iterate {
-		if entry.Target != "" {
+		if entry.Target != "" && !entry.IsDir() {
 			validTargets[entry.Target] = true
 		}
 	}
@@ -648,8 +664,8 @@ func cleanLookupTable(lt *util.LookupTable, filesInArchive map[string]bool, verb
 	var removed int
 
 	for entry := range lt.Iterate {
-		// Keep deletion markers (empty target)
-		if entry.Target == "" {
+		// Keep deletion markers (empty target) and directories
+		if entry.Target == "" || entry.IsDir() {
 			cleaned.Add(entry)
 			continue
 		}
@@ -150,6 +150,7 @@ func CreateDJAFSArchive(path, output string, includeSubdirs bool) error {
 // It generates a manifest, compresses the directory, and creates the final archive.
 // The relativePath parameter determines where in the output directory structure the archive will be created.
 // If includeSubdirs is true, subdirectories are included in the archive.
+// The archive is not recorded in the target index; see TargetIndexFile.
 func CreateDJAFSArchiveWithPath(path, output, relativePath string, includeSubdirs bool) error {
 	filesOnly := !includeSubdirs
 	lt := LookupTable{sorted: false, entries: []LookupEntry{}}
@@ -237,7 +238,7 @@ func CreateDJAFSArchiveWithPath(path, output, relativePath string, includeSubdir
 	if err != nil {
 		return fmt.Errorf("failed to write metadata: %w", err)
 	}
-	
+
 	return nil
 }
 
@@ -12,11 +12,14 @@ import (
 
 type (
 	LookupEntry struct {
-		FileSize int64     `json:"size"`     // size of the file in bytes
-		Inode    uint64    `json:"inode"`    // inode number of the file
-		Modified time.Time `json:"modified"` // modification time of the file
-		Name     string    `json:"name"`     // name of the file as it appears in FUSE
-		Target   string    `json:"target"`   // content-addressed name in format "bucket-subbucket-hash", used as both archive entry name and work dir filename
+		FileSize int64     `json:"size"`           // size of the file in bytes
+		Inode    uint64    `json:"inode"`          // inode number of the file
+		Modified time.Time `json:"modified"`       // modification time of the file
+		Name     string    `json:"name"`           // name of the file as it appears in FUSE
+		Target   string    `json:"target"`         // content-addressed name in format "bucket-subbucket-hash", used as both archive entry name and work dir filename
+		Mode     uint32    `json:"mode,omitempty"` // POSIX st_mode including the file type bits, 0 if not recorded
+		Uid      uint32    `json:"uid,omitempty"`  // owner, recorded together with Mode
+		Gid      uint32    `json:"gid,omitempty"`  // group, recorded together with Mode
 	}
 	LookupTable struct {
 		entries []LookupEntry
@@ -24,6 +27,21 @@ type (
 	}
 )
 
+// DirTarget is the Target of entries that record a directory instead of a
+// file, so that directories without files in them persist.
+const DirTarget = "/"
+
+// IsDir reports whether the entry records a directory.
+func (e LookupEntry) IsDir() bool {
+	return e.Target == DirTarget
+}
+
+// HasOwner reports whether the entry records a mode and owner. Entries
+// converted from archives without that data leave them to the mount.
+func (e LookupEntry) HasOwner() bool {
+	return e.Mode != 0
+}
+
 func (e *LookupTable) UnmarshalJSON(data []byte) error {
 	var aux struct {
 		Entries []LookupEntry `json:"entries"`
@@ -47,6 +65,18 @@ func (e LookupTable) MarshalJSON() ([]byte, error) {
 	})
 }
 
+// ReadLookupTable decodes the lookup table stored at path.
+func ReadLookupTable(path string) (LookupTable, error) {
+	var lt LookupTable
+	f, err := os.Open(path)
+	if err != nil {
+		return lt, err
+	}
+	defer f.Close()
+	err = json.NewDecoder(f).Decode(&lt)
+	return lt, err
+}
+
 func (e LookupTable) Iterate(yield func(LookupEntry) bool) {
 	for _, entry := range e.entries {
 		if !yield(entry) {
@@ -75,8 +105,10 @@ func (e LookupTable) Get(index int) LookupEntry {
 	return e.entries[index]
 }
 
+// Sort orders entries by modification time, keeping the order of entries
+// modified at the same time since later ones supersede earlier ones.
 func (e *LookupTable) Sort() {
-	sort.Sort(e)
+	sort.Stable(e)
 	e.sorted = true
 }
 
@@ -149,20 +181,26 @@ func (l *LookupTable) GetNewestFileTS() time.Time {
 	return l.Get(l.Len() - 1).Modified
 }
 
-// GetTotalFileCount returns the total number of content-unique files in the lookup table
+// GetTotalFileCount returns the total number of name-unique files in the lookup table.
+// Names only ever recorded as directories don't count.
 func (l *LookupTable) GetTotalFileCount() int {
 	files := make(map[string]bool)
 	for e := range l.Iterate {
-		files[e.Name] = true
+		if e.Target != "" && !e.IsDir() {
+			files[e.Name] = true
+		}
 	}
 	return len(files)
 }
 
-// GetTargetFileCount returns the total number of name-unique files in the lookup table
+// GetTargetFileCount returns the total number of content-unique files in the lookup table.
+// Directory entries and tombstones have no content.
 func (l *LookupTable) GetTargetFileCount() int {
 	files := make(map[string]bool)
 	for e := range l.Iterate {
-		files[e.Target] = true
+		if e.Target != "" && !e.IsDir() {
+			files[e.Target] = true
+		}
 	}
 	return len(files)
 }
@@ -172,8 +210,8 @@ func (l *LookupTable) GetActiveFileCount() int {
 	// Track latest state for each file name
 	files := make(map[string]bool)
 	for e := range l.Iterate {
-		// Empty Target means deletion
-		files[e.Name] = e.Target != ""
+		// Empty Target means deletion, directories aren't files
+		files[e.Name] = e.Target != "" && !e.IsDir()
 	}
 	// Count only active files
 	count := 0
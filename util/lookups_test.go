@@ -125,6 +125,16 @@ func TestLookupTable_GetActiveFileCount(t *testing.T) {
 			},
 			want: 2, // file1 and file3 active, file2 deleted
 		},
+		{
+			name: "directories",
+			entries: []LookupEntry{
+				{Name: "dir1", Target: DirTarget},
+				{Name: "dir2", Target: DirTarget},
+				{Name: "dir2", Target: ""}, // removed
+				{Name: "file1", Target: "hash1"},
+			},
+			want: 1,
+		},
 	}
 
 	for _, tt := range tests {
@@ -142,6 +152,22 @@ func TestLookupTable_GetActiveFileCount(t *testing.T) {
 	}
 }
 
+func TestLookupTable_FileCountsSkipDirectories(t *testing.T) {
+	lt := &LookupTable{}
+	lt.Add(LookupEntry{Name: "dir", Target: DirTarget})
+	lt.Add(LookupEntry{Name: "dir", Target: ""}) // removed
+	lt.Add(LookupEntry{Name: "a.json", Target: "hash1"})
+	lt.Add(LookupEntry{Name: "b.json", Target: "hash1"})
+	lt.Add(LookupEntry{Name: "b.json", Target: ""}) // deleted
+
+	if got := lt.GetTotalFileCount(); got != 2 {
+		t.Errorf("GetTotalFileCount() = %d, want 2", got)
+	}
+	if got := lt.GetTargetFileCount(); got != 1 {
+		t.Errorf("GetTargetFileCount() = %d, want 1", got)
+	}
+}
+
 func TestLookupTable_GetOldestFileTS(t *testing.T) {
 	lt := &LookupTable{}
 
@@ -18,7 +18,9 @@ var (
 )
 
 // CopyToWorkDir copies a file to the work directory with the specified hash as filename.
-// It validates that the source is a file (not directory) and returns the destination path.
+// The copy is placed in the bucket directory of its hash path, which GCWorkDirs packs
+// into the matching archive. It validates that the source is a file (not directory)
+// and returns the destination path.
 func CopyToWorkDir(path, workDirPath, hash string) (string, error) {
 	stat, err := os.Stat(path)
 	if err != nil {
@@ -29,7 +31,6 @@ func CopyToWorkDir(path, workDirPath, hash string) (string, error) {
 	}
 
 	hashPath := HashPathFromHash(hash)
-	workspacePath := filepath.Join(workDirPath, hashPath)
 
 	// Create directory structure if it doesn't exist
 	workspacePrefix, err := WorkspacePrefixFromHashPath(hashPath)
@@ -38,6 +39,9 @@ func CopyToWorkDir(path, workDirPath, hash string) (string, error) {
 	}
 	workspacePrefix = filepath.Join(workDirPath, workspacePrefix)
 
+	// Inside the work directory GCWorkDirs packs into the target's archive
+	workspacePath := filepath.Join(workspacePrefix, hashPath)
+
 	gcLock.Lock()
 	defer gcLock.Unlock()
 
@@ -178,6 +182,8 @@ func GCWorkDirs(workDirPath string) error {
 
 // PackWorkDir packs a work directory into a ZIP archive.
 // It checks if an existing archive exists and merges the contents if necessary.
+// The packed targets are recorded in the target index of the storage directory
+// that holds dataDir.
 func PackWorkDir(workDir, basePath, dataDir string) error {
 	zipPath := WorkDirPathToZipPath(workDir, basePath, dataDir)
 
@@ -188,7 +194,38 @@ func PackWorkDir(workDir, basePath, dataDir string) error {
 		}
 	}
 
-	return CompressDirectoryToDest(workDir, zipPath)
+	// Write next to the archive and rename it into place, so readers holding
+	// the old archive open keep a consistent file and new readers never see
+	// a partial one. Callers remove the packed content once this returns,
+	// so the archive must be on stable storage by then.
+	tmpPath := zipPath + ".tmp"
+	err := CompressDirectoryToDest(workDir, tmpPath)
+	if err == nil {
+		err = syncPath(tmpPath)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, zipPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := syncPath(dataDir); err != nil {
+		return err
+	}
+
+	// The work dir now mirrors the archive contents, record them all
+	dirents, err := os.ReadDir(workDir)
+	if err != nil {
+		return err
+	}
+	var targets []string
+	for _, d := range dirents {
+		if !d.IsDir() && isTarget(d.Name()) {
+			targets = append(targets, d.Name())
+		}
+	}
+	return RecordTargets(filepath.Dir(dataDir), zipPath, targets...)
 }
 
 // extractZipToDir extracts all files from a ZIP archive into a directory.
@@ -230,3 +267,14 @@ func extractZipToDir(zipPath, destDir string) error {
 	}
 	return nil
 }
+
+// syncPath flushes the file or directory at p to stable storage
+func syncPath(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	f.Close()
+	return err
+}
@@ -256,6 +256,13 @@ func TestCopyToWorkDir(t *testing.T) {
 		t.Errorf("Dest path %q should be under work dir %q", destPath, workDir)
 	}
 
+	// The copy sits in the bucket GCWorkDirs packs
+	hashPath := HashPathFromHash(hash)
+	prefix, _ := WorkspacePrefixFromHashPath(hashPath)
+	if want := filepath.Join(workDir, prefix, hashPath); destPath != want {
+		t.Errorf("Dest path %q, want %q", destPath, want)
+	}
+
 	// Verify file was copied
 	content, err := os.ReadFile(destPath)
 	if err != nil {
@@ -0,0 +1,439 @@
+package util
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TargetIndexFile is the name of the target index, stored at the root of the
+// storage directory. It maps each content-addressed Target to the .djfz
+// archive holding it so reads never have to scan archives. PackWorkDir and
+// repacking keep it current; archives written by CreateDJAFSArchiveWithPath
+// are only indexed by RebuildTargetIndex or once a read finds them.
+const TargetIndexFile = "targets.djfi"
+
+// TargetJournalFile is the log of targets recorded since the target index
+// was last written, stored next to it. RecordTargets only appends to it, so
+// recording is independent of the size of the index.
+const TargetJournalFile = "targets.log"
+
+// targetJournalLimit is the journal size below which RecordTargets never
+// folds the journal into the index
+const targetJournalLimit = 64 << 10
+
+// TargetIndex is an in-memory view of a storage directory's target index.
+type TargetIndex struct {
+	Targets map[string]string `json:"targets"` // target -> archive path relative to storage
+
+	storagePath string
+	modTime     time.Time // of the index file when it was loaded
+	journalSize int64     // journal bytes applied
+	mu          sync.RWMutex
+}
+
+// targetRecord is a line of the target journal
+type targetRecord struct {
+	Target  string `json:"target"`
+	Archive string `json:"archive"` // relative to storage
+}
+
+// LoadTargetIndex reads the target index of storagePath.
+// A missing index yields an empty one.
+func LoadTargetIndex(storagePath string) (*TargetIndex, error) {
+	ti := &TargetIndex{storagePath: storagePath}
+	err := ti.load()
+	return ti, err
+}
+
+// load replaces the in-memory index with the one on disk.
+func (ti *TargetIndex) load() error {
+	unlock, err := lockTargetJournal(ti.storagePath, syscall.LOCK_SH)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	onDisk, info, err := readTargetIndex(ti.storagePath)
+	records, size, journalErr := readTargetJournal(ti.storagePath, 0)
+	if err == nil {
+		err = journalErr
+	}
+	for _, r := range records {
+		onDisk.Targets[r.Target] = r.Archive
+	}
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	ti.Targets = onDisk.Targets
+	ti.modTime = time.Time{}
+	if info != nil {
+		ti.modTime = info.ModTime()
+	}
+	ti.journalSize = size
+	return err
+}
+
+// refresh picks up targets recorded on disk since the index was loaded. A
+// grown journal is read from where it was left off; a rewritten index is
+// loaded again. It reports whether anything changed.
+func (ti *TargetIndex) refresh() bool {
+	info, err := os.Stat(filepath.Join(ti.storagePath, TargetIndexFile))
+	var modTime time.Time
+	if err == nil {
+		modTime = info.ModTime()
+	}
+	size := journalSize(ti.storagePath)
+
+	ti.mu.RLock()
+	rewritten := !modTime.Equal(ti.modTime) || size < ti.journalSize
+	grown := size > ti.journalSize
+	offset := ti.journalSize
+	ti.mu.RUnlock()
+
+	switch {
+	case rewritten:
+		return ti.load() == nil
+	case !grown:
+		return false
+	}
+
+	unlock, err := lockTargetJournal(ti.storagePath, syscall.LOCK_SH)
+	if err != nil {
+		return false
+	}
+	records, size, err := readTargetJournal(ti.storagePath, offset)
+	unlock()
+	if err != nil {
+		return false
+	}
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+	if ti.journalSize != offset {
+		return true // Another lookup caught up meanwhile
+	}
+	for _, r := range records {
+		ti.Targets[r.Target] = r.Archive
+	}
+	ti.journalSize = size
+	return true
+}
+
+// Lookup returns the absolute path of the archive holding target.
+// On a miss the index is refreshed if it changed on disk since it was
+// loaded, so archives packed by other processes are picked up.
+func (ti *TargetIndex) Lookup(target string) (string, bool) {
+	if archivePath, ok := ti.get(target); ok {
+		return archivePath, true
+	}
+	if !ti.refresh() {
+		return "", false
+	}
+	return ti.get(target)
+}
+
+func (ti *TargetIndex) get(target string) (string, bool) {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	rel, ok := ti.Targets[target]
+	if !ok {
+		return "", false
+	}
+	return filepath.Join(ti.storagePath, rel), true
+}
+
+// Set records the archive for target in memory only.
+// Use RecordTargets to persist the mapping.
+func (ti *TargetIndex) Set(target, archivePath string) {
+	rel, err := filepath.Rel(ti.storagePath, archivePath)
+	if err != nil {
+		return
+	}
+	ti.mu.Lock()
+	ti.Targets[target] = rel
+	ti.mu.Unlock()
+}
+
+// Forget drops a mapping that turned out to be stale.
+func (ti *TargetIndex) Forget(target string) {
+	ti.mu.Lock()
+	delete(ti.Targets, target)
+	ti.mu.Unlock()
+}
+
+// Len returns the number of indexed targets.
+func (ti *TargetIndex) Len() int {
+	ti.mu.RLock()
+	defer ti.mu.RUnlock()
+	return len(ti.Targets)
+}
+
+// save durably replaces the index file. The caller must hold the journal
+// lock exclusively.
+func (ti *TargetIndex) save() error {
+	indexPath := filepath.Join(ti.storagePath, TargetIndexFile)
+	tmpPath := indexPath + ".tmp"
+
+	ti.mu.RLock()
+	err := WriteJSONFile(tmpPath, ti)
+	ti.mu.RUnlock()
+	if err == nil {
+		err = syncPath(tmpPath)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, indexPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return syncPath(ti.storagePath)
+}
+
+// RecordTargets persists that targets are stored in archivePath by
+// appending them to the target journal. archivePath must be inside
+// storagePath. Once the journal outgrows the index it is folded into it.
+func RecordTargets(storagePath, archivePath string, targets ...string) error {
+	if len(targets) == 0 {
+		return nil
+	}
+	rel, err := filepath.Rel(storagePath, archivePath)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, target := range targets {
+		enc.Encode(targetRecord{Target: target, Archive: rel})
+	}
+
+	unlock, err := lockTargetJournal(storagePath, syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	journalPath := filepath.Join(storagePath, TargetJournalFile)
+	f, err := os.OpenFile(journalPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	var size int64
+	if info, statErr := f.Stat(); err == nil && statErr == nil {
+		size = info.Size()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Folding rewrites the whole index, which the journal growing by as
+	// much again pays for
+	var indexSize int64
+	if info, err := os.Stat(filepath.Join(storagePath, TargetIndexFile)); err == nil {
+		indexSize = info.Size()
+	}
+	if size < max(indexSize, targetJournalLimit) {
+		return nil
+	}
+	return compactTargets(storagePath)
+}
+
+// compactTargets folds the journal into the index. The caller must hold
+// the journal lock exclusively.
+func compactTargets(storagePath string) error {
+	onDisk, _, err := readTargetIndex(storagePath)
+	if err != nil {
+		return err
+	}
+	records, _, err := readTargetJournal(storagePath, 0)
+	if err != nil {
+		return err
+	}
+	ti := &TargetIndex{Targets: onDisk.Targets, storagePath: storagePath}
+	for _, r := range records {
+		ti.Targets[r.Target] = r.Archive
+	}
+	if err := ti.save(); err != nil {
+		return err
+	}
+	return os.Truncate(filepath.Join(storagePath, TargetJournalFile), 0)
+}
+
+// RebuildTargetIndex scans every .djfz archive under storagePath and replaces
+// the target index with what it finds. Targets recorded while it scans are
+// kept. It returns the number of targets indexed.
+func RebuildTargetIndex(storagePath string) (int, error) {
+	ti := &TargetIndex{
+		Targets:     make(map[string]string),
+		storagePath: storagePath,
+	}
+
+	// Records appended from here on may be for archives already scanned
+	scanFrom := journalSize(storagePath)
+
+	err := filepath.WalkDir(storagePath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, ".djfz") {
+			return nil
+		}
+
+		zrc, err := zip.OpenReader(path)
+		if err != nil {
+			return nil // Corrupted archives are reported by validation
+		}
+		defer zrc.Close()
+
+		for _, f := range zrc.File {
+			if isTarget(f.Name) {
+				ti.Set(f.Name, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	unlock, err := lockTargetJournal(storagePath, syscall.LOCK_EX)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	// A journal folded into the index meanwhile only holds newer records
+	if journalSize(storagePath) < scanFrom {
+		scanFrom = 0
+	}
+	records, size, err := readTargetJournal(storagePath, scanFrom)
+	if err != nil {
+		return 0, err
+	}
+	for _, r := range records {
+		ti.Targets[r.Target] = r.Archive
+	}
+	if err := ti.save(); err != nil {
+		return 0, err
+	}
+	if size > 0 {
+		if err := os.Truncate(filepath.Join(storagePath, TargetJournalFile), 0); err != nil {
+			return 0, err
+		}
+	}
+	return ti.Len(), nil
+}
+
+// journalSize returns the size of the target journal of storagePath
+func journalSize(storagePath string) int64 {
+	info, err := os.Stat(filepath.Join(storagePath, TargetJournalFile))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// readTargetIndex reads the index file of storagePath. A missing index is
+// empty.
+func readTargetIndex(storagePath string) (*TargetIndex, os.FileInfo, error) {
+	onDisk := &TargetIndex{}
+	indexPath := filepath.Join(storagePath, TargetIndexFile)
+	info, err := os.Stat(indexPath)
+	if err == nil {
+		var f *os.File
+		f, err = os.Open(indexPath)
+		if err == nil {
+			err = json.NewDecoder(f).Decode(onDisk)
+			f.Close()
+		}
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if onDisk.Targets == nil {
+		onDisk.Targets = make(map[string]string)
+	}
+	return onDisk, info, err
+}
+
+// readTargetJournal reads the journal of storagePath from byte offset on.
+// It returns the records and the offset after the last complete line;
+// unreadable lines, such as one a crash cut short, are skipped.
+func readTargetJournal(storagePath string, offset int64) ([]targetRecord, int64, error) {
+	f, err := os.Open(filepath.Join(storagePath, TargetJournalFile))
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+
+	var records []targetRecord
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return records, offset, nil
+		}
+		if err != nil {
+			return records, offset, err
+		}
+		offset += int64(len(line))
+		var record targetRecord
+		if json.Unmarshal(line, &record) == nil && record.Target != "" {
+			records = append(records, record)
+		}
+	}
+}
+
+// lockTargetJournal takes a lock on the target journal of storagePath that
+// other processes see too, shared for reading or exclusive for writing. A
+// shared lock on a missing journal is not needed, and never creates it.
+func lockTargetJournal(storagePath string, how int) (func(), error) {
+	flag := os.O_CREATE | os.O_RDONLY
+	if how == syscall.LOCK_SH {
+		flag = os.O_RDONLY
+	}
+	f, err := os.OpenFile(filepath.Join(storagePath, TargetJournalFile), flag, 0o644)
+	if how == syscall.LOCK_SH && os.IsNotExist(err) {
+		return func() {}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// isTarget reports whether an archive entry name is a content-addressed
+// target as produced by HashPathFromHash.
+func isTarget(name string) bool {
+	hash, err := HashFromHashPath(name)
+	return err == nil && len(hash) == 64
+}
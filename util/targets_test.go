@@ -0,0 +1,170 @@
+package util
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testTarget returns a well-formed target name for a short seed
+func testTarget(seed string) string {
+	return HashPathFromHash(strings.Repeat(seed, 64/len(seed)))
+}
+
+func TestRecordTargets_RoundTrip(t *testing.T) {
+	storage := t.TempDir()
+	archive := filepath.Join(storage, DataDir, "1-00000.djfz")
+	a, b := testTarget("a"), testTarget("b")
+
+	if err := RecordTargets(storage, archive, a, b); err != nil {
+		t.Fatalf("RecordTargets failed: %v", err)
+	}
+
+	ti, err := LoadTargetIndex(storage)
+	if err != nil {
+		t.Fatalf("LoadTargetIndex failed: %v", err)
+	}
+	if got, ok := ti.Lookup(a); !ok || got != archive {
+		t.Errorf("Lookup(a) = %q, %v; want %q", got, ok, archive)
+	}
+	if ti.Len() != 2 {
+		t.Errorf("Expected 2 targets, got %d", ti.Len())
+	}
+}
+
+func TestTargetIndex_LookupReloadsOnMiss(t *testing.T) {
+	storage := t.TempDir()
+	first := filepath.Join(storage, DataDir, "1-00000.djfz")
+	second := filepath.Join(storage, DataDir, "2-00000.djfz")
+
+	if err := RecordTargets(storage, first, testTarget("a")); err != nil {
+		t.Fatalf("RecordTargets failed: %v", err)
+	}
+	ti, err := LoadTargetIndex(storage)
+	if err != nil {
+		t.Fatalf("LoadTargetIndex failed: %v", err)
+	}
+
+	// Another writer records a target after the index was loaded
+	if err := RecordTargets(storage, second, testTarget("c")); err != nil {
+		t.Fatalf("RecordTargets failed: %v", err)
+	}
+	// Force a visible mtime change regardless of filesystem timestamp granularity
+	ti.modTime = ti.modTime.Add(-1)
+
+	if got, ok := ti.Lookup(testTarget("c")); !ok || got != second {
+		t.Errorf("Lookup after reload = %q, %v; want %q", got, ok, second)
+	}
+	if _, ok := ti.Lookup(testTarget("a")); !ok {
+		t.Error("Earlier targets should survive a reload")
+	}
+}
+
+func TestRebuildTargetIndex(t *testing.T) {
+	storage := t.TempDir()
+	dataDir := filepath.Join(storage, DataDir)
+	os.MkdirAll(dataDir, 0o755)
+
+	target := testTarget("d")
+	archivePath := filepath.Join(dataDir, "7-00000.djfz")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	w := zip.NewWriter(f)
+	for _, name := range []string{target, "lookups.djfl", "reading.json"} {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		fw.Write([]byte("{}"))
+	}
+	w.Close()
+	f.Close()
+
+	// A stale entry must not survive a rebuild
+	if err := RecordTargets(storage, filepath.Join(dataDir, "gone.djfz"), testTarget("e")); err != nil {
+		t.Fatalf("RecordTargets failed: %v", err)
+	}
+
+	n, err := RebuildTargetIndex(storage)
+	if err != nil {
+		t.Fatalf("RebuildTargetIndex failed: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 indexed target, got %d", n)
+	}
+
+	ti, _ := LoadTargetIndex(storage)
+	if got, ok := ti.Lookup(target); !ok || got != archivePath {
+		t.Errorf("Lookup = %q, %v; want %q", got, ok, archivePath)
+	}
+	if _, ok := ti.Lookup(testTarget("e")); ok {
+		t.Error("Stale target should be dropped by rebuild")
+	}
+}
+
+func TestPackWorkDir_RecordsTargets(t *testing.T) {
+	storage := t.TempDir()
+	workDir := filepath.Join(storage, WorkDir)
+	dataDir := filepath.Join(storage, DataDir)
+
+	target := testTarget("f")
+	bucket := filepath.Join(workDir, "123", "00000")
+	os.MkdirAll(bucket, 0o755)
+	os.MkdirAll(dataDir, 0o755)
+	os.WriteFile(filepath.Join(bucket, target), []byte("content"), 0o644)
+
+	if err := PackWorkDir(bucket, workDir, dataDir); err != nil {
+		t.Fatalf("PackWorkDir failed: %v", err)
+	}
+
+	ti, _ := LoadTargetIndex(storage)
+	want := filepath.Join(dataDir, "123-00000.djfz")
+	if got, ok := ti.Lookup(target); !ok || got != want {
+		t.Errorf("Lookup = %q, %v; want %q", got, ok, want)
+	}
+}
+
+func TestRecordTargets_AppendsToJournal(t *testing.T) {
+	storage := t.TempDir()
+	archive := filepath.Join(storage, DataDir, "1-00000.djfz")
+
+	if err := RecordTargets(storage, archive, testTarget("a")); err != nil {
+		t.Fatalf("RecordTargets failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(storage, TargetIndexFile)); !os.IsNotExist(err) {
+		t.Error("Recording a target should not rewrite the index")
+	}
+
+	// A journal past the limit is folded into the index
+	var targets []string
+	for i := range targetJournalLimit / 100 {
+		targets = append(targets, testTarget(fmt.Sprintf("%04x", i)))
+	}
+	if err := RecordTargets(storage, archive, targets...); err != nil {
+		t.Fatalf("RecordTargets failed: %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(storage, TargetJournalFile)); err != nil || info.Size() != 0 {
+		t.Errorf("Expected an empty journal after folding, got %v, %v", info, err)
+	}
+
+	ti, err := LoadTargetIndex(storage)
+	if err != nil {
+		t.Fatalf("LoadTargetIndex failed: %v", err)
+	}
+	if ti.Len() != len(targets)+1 {
+		t.Errorf("Expected %d targets, got %d", len(targets)+1, ti.Len())
+	}
+
+	// Later records are picked up from the journal alone
+	if err := RecordTargets(storage, archive, testTarget("b")); err != nil {
+		t.Fatalf("RecordTargets failed: %v", err)
+	}
+	if got, ok := ti.Lookup(testTarget("b")); !ok || got != archive {
+		t.Errorf("Lookup = %q, %v; want %q", got, ok, archive)
+	}
+}